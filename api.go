@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/angelofallars/htmx-chi-todo/auth"
+	"github.com/angelofallars/htmx-chi-todo/openapi"
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/angelofallars/htmx-chi-todo/todo"
+	"github.com/angelofallars/htmx-chi-todo/user"
+	"github.com/go-chi/chi/v5"
+)
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// The base path of the JSON API
+const apiPath = "/api/v1"
+
+// The JSON API, authenticated by bearer tokens from POST /token or API tokens
+// from the account page, and its OpenAPI document
+func newAPIRouter(sessions auth.Sessions, userService user.Service, todoService todo.Service) (chi.Router, *openapi.Document) {
+	r := chi.NewRouter()
+	userAPI := user.NewAPIHandler(userService)
+	todoAPI := todo.NewAPIHandler(todoService)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		svc.WriteJSONError(w, svc.ErrNotExists)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		svc.WriteJSONErrorCode(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	})
+
+	r.Use(sessions.BearerVerifier())
+
+	userAPI.Mount(r)
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireToken)
+		r.Use(auth.RequireMethodScope)
+
+		todoAPI.Mount(r)
+	})
+
+	return r, openapi.New("htmx-chi-todo", "1.0.0", apiPath, userAPI, todoAPI)
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/angelofallars/htmx-chi-todo/auth"
+	"github.com/angelofallars/htmx-chi-todo/config"
+	"github.com/angelofallars/htmx-chi-todo/mail"
+	"github.com/angelofallars/htmx-chi-todo/migrate"
+	"github.com/angelofallars/htmx-chi-todo/openapi"
+	"github.com/angelofallars/htmx-chi-todo/ratelimit"
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/angelofallars/htmx-chi-todo/todo"
+	"github.com/angelofallars/htmx-chi-todo/user"
+	"github.com/go-chi/chi/v5"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type testAPI struct {
+	chi.Router
+	doc      *openapi.Document
+	sessions auth.Sessions
+	// The ID of alice
+	userID string
+}
+
+// A JSON API backed by an in-memory database, with a verified user named
+// alice whose password is password123
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to ":memory:" opens a separate database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, migrate.Migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	hmac, err := auth.NewHMACKey("hmac", []byte("a-test-secret-of-at-least-32-bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeyProvider(hmac)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := auth.NewSessions(keys, auth.NewSQLiteTokenStore(db), auth.NewCookies(true), config.Default().Auth)
+	userRepo := user.NewSQLiteRepository(db)
+	todoService := todo.NewService(todo.NewSQLiteRepository(db), todo.NewMemoryEventBus(), user.NewDirectory(userRepo))
+	policy := ratelimit.Policy{FreeFailures: 100, BaseLockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+
+	userService := user.NewService(
+		userRepo,
+		user.NewSQLiteResetTokenStore(db),
+		sessions,
+		user.Emails{
+			Keys:    keys,
+			Mailer:  mail.NewLogMailer(io.Discard, "noreply@example.com"),
+			BaseURL: "https://todo.example.com",
+		},
+		user.LoginLimits{
+			ByIP:       ratelimit.NewLimiter(ratelimit.NewMemoryStore(), policy),
+			ByUsername: ratelimit.NewLimiter(ratelimit.NewMemoryStore(), policy),
+		},
+		todoService,
+	)
+
+	password, err := user.NewHashedPassword("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := user.NewUser("alice", password, "alice@example.com")
+	u.EmailVerifiedAt = time.Now()
+	if err := userRepo.CreateUser(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+
+	router, doc := newAPIRouter(sessions, userService, todoService)
+	return &testAPI{
+		Router:   router,
+		doc:      doc,
+		sessions: sessions,
+		userID:   u.ID.String(),
+	}
+}
+
+// Send a request with a JSON body, if any, and decode the JSON response into
+// res, if any
+func doJSON(t *testing.T, api http.Handler, method string, path string, token string, body any, res any) int {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req := httptest.NewRequest(method, path, reqBody)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	if res != nil && w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatalf("%v %v: decoding %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+
+	return w.Code
+}
+
+func login(t *testing.T, api http.Handler) user.TokenResponse {
+	t.Helper()
+
+	var tokens user.TokenResponse
+	code := doJSON(t, api, http.MethodPost, "/token", "", user.TokenReq{Username: "alice", Password: "password123"}, &tokens)
+	if code != http.StatusOK {
+		t.Fatalf("logging in: got status %v", code)
+	}
+	return tokens
+}
+
+func TestAPITodoLists(t *testing.T) {
+	api := newTestAPI(t)
+	token := login(t, api).AccessToken
+
+	var list todo.ListDTO
+	code := doJSON(t, api, http.MethodPost, "/lists", token, todo.CreateListReq{Title: "Groceries"}, &list)
+	if code != http.StatusCreated || list.Title != "Groceries" {
+		t.Fatalf("creating a list: got status %v and %+v", code, list)
+	}
+
+	var item todo.ItemDTO
+	dueAt := time.Date(2030, 1, 2, 9, 0, 0, 0, time.UTC)
+	code = doJSON(t, api, http.MethodPost, "/lists/"+list.ID.String()+"/items", token,
+		todo.CreateItemReq{Title: "Milk", DueAt: &dueAt, DueTimeZone: "Asia/Manila"}, &item)
+	if code != http.StatusCreated {
+		t.Fatalf("creating an item: got status %v", code)
+	}
+	if item.DueAt == nil || !item.DueAt.Equal(dueAt) || item.DueTimeZone != "Asia/Manila" {
+		t.Errorf("got due date %v in %q, want %v in Asia/Manila", item.DueAt, item.DueTimeZone, dueAt)
+	}
+
+	isDone := true
+	code = doJSON(t, api, http.MethodPatch, "/items/"+item.ID.String(), token, todo.UpdateItemReq{IsDone: &isDone}, &item)
+	if code != http.StatusOK || !item.IsDone || item.Title != "Milk" {
+		t.Errorf("completing an item: got status %v and %+v", code, item)
+	}
+
+	var lists todo.ListsResponse
+	code = doJSON(t, api, http.MethodGet, "/lists", token, nil, &lists)
+	if code != http.StatusOK || len(lists.Lists) != 1 || len(lists.Lists[0].Items) != 1 {
+		t.Errorf("getting lists: got status %v and %+v", code, lists)
+	}
+
+	code = doJSON(t, api, http.MethodDelete, "/lists/"+list.ID.String(), token, nil, nil)
+	if code != http.StatusNoContent {
+		t.Errorf("deleting a list: got status %v", code)
+	}
+
+	var errRes svc.ErrorResponse
+	code = doJSON(t, api, http.MethodGet, "/lists/"+list.ID.String(), token, nil, &errRes)
+	if code != http.StatusNotFound || errRes.Error.Code != "not_found" {
+		t.Errorf("getting a deleted list: got status %v and %+v", code, errRes)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	api := newTestAPI(t)
+	token := login(t, api).AccessToken
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		body     any
+		wantCode int
+		wantErr  string
+	}{
+		{"no token", http.MethodGet, "/lists", "", nil, http.StatusUnauthorized, "unauthorized"},
+		{"invalid token", http.MethodGet, "/lists", "not-a-token", nil, http.StatusUnauthorized, "unauthorized"},
+		{"wrong password", http.MethodPost, "/token", "", user.TokenReq{Username: "alice", Password: "wrong"}, http.StatusUnauthorized, "unauthorized"},
+		{"empty title", http.MethodPost, "/lists", token, todo.CreateListReq{}, http.StatusBadRequest, "validation"},
+		{"unknown field", http.MethodPost, "/lists", token, map[string]string{"name": "Groceries"}, http.StatusBadRequest, "validation"},
+		{"no body", http.MethodPost, "/lists", token, nil, http.StatusBadRequest, "validation"},
+		{"malformed ID", http.MethodGet, "/lists/not-an-id", token, nil, http.StatusNotFound, "not_found"},
+		{"unknown route", http.MethodGet, "/nothing", token, nil, http.StatusNotFound, "not_found"},
+		{"wrong method", http.MethodPut, "/token", "", nil, http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res svc.ErrorResponse
+			code := doJSON(t, api, tt.method, tt.path, tt.token, tt.body, &res)
+			if code != tt.wantCode || res.Error.Code != tt.wantErr || res.Error.Message == "" {
+				t.Errorf("got status %v and %+v, want %v and code %q", code, res, tt.wantCode, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	api := newTestAPI(t)
+	tokens := login(t, api)
+
+	var me user.UserDTO
+	code := doJSON(t, api, http.MethodGet, "/me", tokens.AccessToken, nil, &me)
+	if code != http.StatusOK || me.Username != "alice" || me.EmailVerifiedAt == nil {
+		t.Errorf("getting the user: got status %v and %+v", code, me)
+	}
+
+	var refreshed user.TokenResponse
+	code = doJSON(t, api, http.MethodPost, "/token/refresh", "", user.RefreshTokenReq{RefreshToken: tokens.RefreshToken}, &refreshed)
+	if code != http.StatusOK || refreshed.RefreshToken == tokens.RefreshToken {
+		t.Fatalf("refreshing: got status %v and %+v", code, refreshed)
+	}
+
+	code = doJSON(t, api, http.MethodPost, "/token/revoke", refreshed.AccessToken, user.RefreshTokenReq{RefreshToken: refreshed.RefreshToken}, nil)
+	if code != http.StatusNoContent {
+		t.Errorf("revoking: got status %v", code)
+	}
+
+	if code := doJSON(t, api, http.MethodGet, "/me", refreshed.AccessToken, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("revoked access token: got status %v, want 401", code)
+	}
+	code = doJSON(t, api, http.MethodPost, "/token/refresh", "", user.RefreshTokenReq{RefreshToken: refreshed.RefreshToken}, nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("revoked refresh token: got status %v, want 401", code)
+	}
+}
+
+// Every route mounted on the API must be in the OpenAPI document, and every
+// route in the document must be mounted
+func TestAPIDocumentMatchesRoutes(t *testing.T) {
+	api := newTestAPI(t)
+
+	mounted := make([]string, 0)
+	err := chi.Walk(api, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		mounted = append(mounted, method+" "+route)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(mounted)
+
+	documented := api.doc.Routes()
+	for _, route := range mounted {
+		if !slices.Contains(documented, route) {
+			t.Errorf("%v is mounted but not in the OpenAPI document", route)
+		}
+	}
+	for _, route := range documented {
+		if !slices.Contains(mounted, route) {
+			t.Errorf("%v is in the OpenAPI document but not mounted", route)
+		}
+	}
+}
+
+func TestAPIDocument(t *testing.T) {
+	api := newTestAPI(t)
+
+	w := httptest.NewRecorder()
+	openapi.Handler(api.doc).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+
+	var res struct {
+		OpenAPI    string                    `json:"openapi"`
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.OpenAPI != openapi.Version {
+		t.Errorf("got OpenAPI version %q, want %q", res.OpenAPI, openapi.Version)
+	}
+	if _, ok := res.Paths["/lists/{id}"]["patch"]; !ok {
+		t.Errorf("PATCH /lists/{id} is missing from the paths")
+	}
+	for _, name := range []string{"ListDTO", "ItemDTO", "UpdateListReq", "TokenResponse", "ErrorResponse"} {
+		if _, ok := res.Components.Schemas[name]; !ok {
+			t.Errorf("schema %v is missing from the components", name)
+		}
+	}
+}
+
+func TestAPIPersonalTokens(t *testing.T) {
+	api := newTestAPI(t)
+	ctx := context.Background()
+
+	readOnly, _, err := api.sessions.CreateAPIToken(ctx, api.userID, "dashboard", []auth.Scope{auth.ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readWrite, importer, err := api.sessions.CreateAPIToken(ctx, api.userID, "importer", []auth.Scope{auth.ScopeWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list todo.ListDTO
+	code := doJSON(t, api, http.MethodPost, "/lists", readWrite, todo.CreateListReq{Title: "Imported"}, &list)
+	if code != http.StatusCreated {
+		t.Fatalf("creating a list: got status %v", code)
+	}
+
+	// Lists made with the token belong to the user
+	var lists todo.ListsResponse
+	code = doJSON(t, api, http.MethodGet, "/lists", readOnly, nil, &lists)
+	if code != http.StatusOK || len(lists.Lists) != 1 || lists.Lists[0].ID != list.ID {
+		t.Errorf("getting lists: got status %v and %+v", code, lists)
+	}
+	var me user.UserDTO
+	code = doJSON(t, api, http.MethodGet, "/me", readOnly, nil, &me)
+	if code != http.StatusOK || me.Username != "alice" {
+		t.Errorf("getting the user: got status %v and %+v", code, me)
+	}
+
+	var errRes svc.ErrorResponse
+	code = doJSON(t, api, http.MethodDelete, "/lists/"+list.ID.String(), readOnly, nil, &errRes)
+	if code != http.StatusForbidden || errRes.Error.Code != "forbidden" {
+		t.Errorf("deleting with a read-only token: got status %v and %+v", code, errRes)
+	}
+
+	if err := api.sessions.RevokeAPIToken(ctx, api.userID, importer.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := doJSON(t, api, http.MethodGet, "/lists", readWrite, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("revoked token: got status %v, want 401", code)
+	}
+}
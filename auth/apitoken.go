@@ -0,0 +1,141 @@
+// apitoken.go provides personal access tokens, which users make for scripts
+// and integrations to call the API as them
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	jwxjwt "github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// Tells API tokens apart from JWTs, which start with "eyJ"
+const apiTokenPrefix = "todo_"
+
+type Scope string
+
+const (
+	// Read todo lists and items
+	ScopeRead Scope = "read"
+	// Change todo lists and items, which includes reading them
+	ScopeWrite Scope = "write"
+)
+
+// Every scope, in the order they are shown
+var Scopes = []Scope{ScopeRead, ScopeWrite}
+
+var (
+	ErrInvalidAPIToken   = errors.New("invalid or revoked API token")
+	ErrInvalidScope      = errors.New("unknown scope")
+	ErrInsufficientScope = errors.New("the token's scopes do not allow this")
+)
+
+// An API token as it is stored, which is only by its hash
+type APIToken struct {
+	ID     string
+	Hash   string
+	UserID string
+	// Looked up from the user when the token is used, since it can change
+	Username string
+	// What the user made the token for
+	Name       string
+	Scopes     []Scope
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+func ParseScope(s string) (Scope, error) {
+	for _, scope := range Scopes {
+		if s == string(scope) {
+			return scope, nil
+		}
+	}
+	return "", ErrInvalidScope
+}
+
+// Whether any of the scopes allow what needs scope
+func HasScope(scopes []Scope, scope Scope) bool {
+	for _, s := range scopes {
+		if s == scope || (s == ScopeWrite && scope == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scopes are kept space-separated, in the database and in claims
+func joinScopes(scopes []Scope) string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return strings.Join(s, " ")
+}
+
+func splitScopes(s string) []Scope {
+	scopes := make([]Scope, 0)
+	for _, scope := range strings.Fields(s) {
+		scopes = append(scopes, Scope(scope))
+	}
+	return scopes
+}
+
+func isAPIToken(token string) bool {
+	return strings.HasPrefix(token, apiTokenPrefix)
+}
+
+func (s sessions) CreateAPIToken(ctx context.Context, userID string, name string, scopes []Scope) (string, *APIToken, error) {
+	random, err := newRandomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	token := apiTokenPrefix + random
+
+	t := &APIToken{
+		ID:        uuid.NewString(),
+		Hash:      hashToken(token),
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	err = s.store.CreateAPIToken(ctx, t)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, t, nil
+}
+
+func (s sessions) GetAPITokens(ctx context.Context, userID string) ([]*APIToken, error) {
+	return s.store.GetAPITokens(ctx, userID)
+}
+
+func (s sessions) RevokeAPIToken(ctx context.Context, userID string, tokenID string) error {
+	return s.store.DeleteAPIToken(ctx, userID, tokenID)
+}
+
+func (s sessions) RevokeAPITokens(ctx context.Context, userID string) error {
+	return s.store.DeleteAPITokens(ctx, userID)
+}
+
+// The API token as a JWT with the same claims as one from logging in, plus
+// its scopes, so handlers know who it is for either way
+func (s sessions) verifyAPIToken(ctx context.Context, token string) (jwxjwt.Token, error) {
+	t, err := s.store.UseAPIToken(ctx, hashToken(token), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return jwxjwt.NewBuilder().
+		JwtID(t.ID).
+		IssuedAt(t.CreatedAt).
+		Claim("id", t.UserID).
+		Claim("username", t.Username).
+		Claim("scope", joinScopes(t.Scopes)).
+		Build()
+}
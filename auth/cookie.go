@@ -0,0 +1,67 @@
+// cookie.go provides the cookies that hold the session tokens of browsers
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+)
+
+const (
+	AccessTokenCookie  = "jwt"
+	RefreshTokenCookie = "refresh_token"
+)
+
+type Cookies struct {
+	// Only send the cookies over HTTPS
+	Secure bool
+}
+
+func NewCookies(secure bool) Cookies {
+	return Cookies{
+		Secure: secure,
+	}
+}
+
+// Set the cookies of a new pair of tokens, expiring along with the tokens.
+// This must be called before anything is written to w.
+func (c Cookies) SetSession(w http.ResponseWriter, pair *TokenPair) {
+	http.SetCookie(w, c.Cookie(AccessTokenCookie, pair.AccessToken, pair.AccessTokenExpiresAt))
+	http.SetCookie(w, c.Cookie(RefreshTokenCookie, pair.RefreshToken, pair.RefreshTokenExpiresAt))
+}
+
+// Delete the cookies of the session. This must be called before anything
+// is written to w.
+func (c Cookies) ClearSession(w http.ResponseWriter) {
+	for _, name := range []string{AccessTokenCookie, RefreshTokenCookie} {
+		cookie := c.Cookie(name, "", time.Unix(0, 0))
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+// A cookie with the flags every cookie of the site should have. A zero
+// expires makes the cookie last until the browser is closed.
+func (c Cookies) Cookie(name string, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:    name,
+		Value:   value,
+		Path:    "/",
+		Expires: expires,
+		MaxAge:  max(int(time.Until(expires).Seconds()), 0),
+		// Scripts never need the values, so an XSS can't steal them
+		HttpOnly: true,
+		Secure:   c.Secure,
+		// Lax still sends the cookies when following a link to the site
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Get the access and refresh tokens sent by a browser, if any
+func SessionCookies(r *http.Request) (accessToken string, refreshToken string) {
+	if cookie, err := r.Cookie(RefreshTokenCookie); err == nil {
+		refreshToken = cookie.Value
+	}
+	return jwtauth.TokenFromCookie(r), refreshToken
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/go-chi/chi/v5"
+)
+
+type (
+	Handler interface {
+		svc.HandlerMounter
+		JWKS(w http.ResponseWriter, r *http.Request)
+	}
+
+	handler struct {
+		keys KeyProvider
+	}
+)
+
+func NewHandler(keys KeyProvider) Handler {
+	return &handler{
+		keys: keys,
+	}
+}
+
+func (h handler) Mount(r chi.Router) {
+	r.Get("/.well-known/jwks.json", h.JWKS)
+}
+
+// Serve the public keys so other services can verify our tokens
+func (h handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(h.keys.PublicKeys())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	// Keep it short so rotated keys are picked up quickly
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(body)
+}
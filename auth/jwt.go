@@ -13,9 +13,22 @@ type JwtClaims struct {
 	// Reference to user.ID
 	ID       string `json:"id"`
 	Username string `json:"username"`
+	// Shared by every token from the same login
+	SessionID string `json:"sid"`
+	// Space-separated scopes of an API token. Tokens from logging in have
+	// none, and may do anything.
+	Scope string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Whether the token allows what needs scope
+func (c JwtClaims) HasScope(scope Scope) bool {
+	if c.Scope == "" {
+		return true
+	}
+	return HasScope(splitScopes(c.Scope), scope)
+}
+
 // Attempt to extract JwtClaims from request,
 // otherwise return an error
 func JwtClaimsFromRequest(r *http.Request) (*JwtClaims, error) {
@@ -33,9 +46,13 @@ func JwtClaimsFromRequest(r *http.Request) (*JwtClaims, error) {
 		return nil, errors.New("error fetching username from JWT")
 	}
 
+	// Only API tokens have scopes
+	scope, _ := claimsMap["scope"].(string)
+
 	claims := &JwtClaims{
 		ID:       id,
 		Username: username,
+		Scope:    scope,
 	}
 
 	return claims, nil
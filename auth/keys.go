@@ -0,0 +1,261 @@
+// keys.go provides the keys for signing and verifying JWTs
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/angelofallars/htmx-chi-todo/config"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	jwxjwt "github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown JWT signing algorithm")
+	ErrDuplicateKeyID   = errors.New("duplicate JWT key ID")
+)
+
+// A key for signing and verifying JWTs, found by the "kid" in the token
+// header when verifying.
+type Key struct {
+	ID        string
+	Algorithm string
+	signKey   any
+	verifyKey jwk.Key
+}
+
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("HMAC secret must not be empty")
+	}
+	return newKey(id, AlgHS256, secret, secret)
+}
+
+func NewRSAKey(id string, private *rsa.PrivateKey) (*Key, error) {
+	return newKey(id, AlgRS256, private, &private.PublicKey)
+}
+
+func NewEd25519Key(id string, private ed25519.PrivateKey) (*Key, error) {
+	return newKey(id, AlgEdDSA, private, private.Public())
+}
+
+func newKey(id string, algorithm string, signKey any, verifyKey any) (*Key, error) {
+	if id == "" {
+		return nil, errors.New("JWT key ID must not be empty")
+	}
+
+	k, err := jwk.FromRaw(verifyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// jwx only verifies with a key if the token uses its algorithm
+	err = errors.Join(
+		k.Set(jwk.KeyIDKey, id),
+		k.Set(jwk.AlgorithmKey, jwa.SignatureAlgorithm(algorithm)),
+		k.Set(jwk.KeyUsageKey, jwk.ForSignature),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		ID:        id,
+		Algorithm: algorithm,
+		signKey:   signKey,
+		verifyKey: k,
+	}, nil
+}
+
+// Parse a PEM encoded PKCS #8 private key, or a PKCS #1 key for RS256.
+func ParsePrivateKey(id string, algorithm string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var private any
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if algorithm == AlgRS256 {
+			return NewRSAKey(id, private)
+		}
+	case ed25519.PrivateKey:
+		if algorithm == AlgEdDSA {
+			return NewEd25519Key(id, private)
+		}
+	}
+
+	return nil, fmt.Errorf("a %T cannot be used for %v", private, algorithm)
+}
+
+func (k *Key) signingMethod() jwt.SigningMethod {
+	switch k.Algorithm {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// Symmetric keys are secret, so they can't be shared with other services.
+func (k *Key) isSymmetric() bool {
+	return k.Algorithm == AlgHS256
+}
+
+type (
+	KeyProvider interface {
+		// Sign the claims with the current key
+		Sign(claims jwt.Claims) (string, error)
+		// Verify a token against the current and previous keys
+		VerifyToken(token string) (jwxjwt.Token, error)
+		// Middleware that verifies the JWT of a request, for use with
+		// jwtauth.Authenticator
+		Verifier() func(http.Handler) http.Handler
+		// The public keys of asymmetric keys, for verifying our tokens
+		PublicKeys() jwk.Set
+	}
+
+	keyProvider struct {
+		current    *Key
+		verifySet  jwk.Set
+		publicKeys jwk.Set
+	}
+)
+
+// New tokens are signed with the current key. The previous keys are only
+// used to verify tokens signed before a rotation, until they expire.
+func NewKeyProvider(current *Key, previous ...*Key) (KeyProvider, error) {
+	p := &keyProvider{
+		current:    current,
+		verifySet:  jwk.NewSet(),
+		publicKeys: jwk.NewSet(),
+	}
+
+	for _, k := range append([]*Key{current}, previous...) {
+		if _, ok := p.verifySet.LookupKeyID(k.ID); ok {
+			return nil, fmt.Errorf("%w: %v", ErrDuplicateKeyID, k.ID)
+		}
+
+		err := p.verifySet.AddKey(k.verifyKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if !k.isSymmetric() {
+			err := p.publicKeys.AddKey(k.verifyKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Load the keys from the config. Without any configured keys, the JWT secret
+// is used as the only key.
+func NewKeyProviderFromConfig(cfg config.Auth) (KeyProvider, error) {
+	if len(cfg.JWTKeys) == 0 {
+		k, err := NewHMACKey("default", []byte(cfg.JWTSecret))
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyProvider(k)
+	}
+
+	keys := make([]*Key, 0, len(cfg.JWTKeys))
+	for _, keyCfg := range cfg.JWTKeys {
+		k, err := loadKey(keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("loading JWT key %v: %w", keyCfg.ID, err)
+		}
+		keys = append(keys, k)
+	}
+
+	return NewKeyProvider(keys[0], keys[1:]...)
+}
+
+func loadKey(cfg config.JWTKey) (*Key, error) {
+	switch cfg.Algorithm {
+	case AlgHS256:
+		return NewHMACKey(cfg.ID, []byte(cfg.Secret))
+	case AlgRS256, AlgEdDSA:
+		data, err := os.ReadFile(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return ParsePrivateKey(cfg.ID, cfg.Algorithm, data)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnknownAlgorithm, cfg.Algorithm)
+	}
+}
+
+func (p keyProvider) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(p.current.signingMethod(), claims)
+	token.Header["kid"] = p.current.ID
+
+	return token.SignedString(p.current.signKey)
+}
+
+func (p keyProvider) VerifyToken(token string) (jwxjwt.Token, error) {
+	t, err := jwxjwt.ParseString(token, jwxjwt.WithKeySet(p.verifySet))
+	if err != nil {
+		return nil, jwtauth.ErrorReason(err)
+	}
+	return t, nil
+}
+
+func (p keyProvider) Verifier() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var token jwxjwt.Token
+			var err error
+
+			tokenString := jwtauth.TokenFromHeader(r)
+			if tokenString == "" {
+				tokenString = jwtauth.TokenFromCookie(r)
+			}
+
+			if tokenString == "" {
+				err = jwtauth.ErrNoTokenFound
+			} else {
+				token, err = p.VerifyToken(tokenString)
+			}
+
+			ctx := jwtauth.NewContext(r.Context(), token, err)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func (p keyProvider) PublicKeys() jwk.Set {
+	return p.publicKeys
+}
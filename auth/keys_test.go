@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestClaims() *JwtClaims {
+	return &JwtClaims{
+		ID:       "8e8b5e8a-2f0b-4b53-9a4a-0f5ee3f0c7a1",
+		Username: "angelo",
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func newTestKeys(t *testing.T) (hmac *Key, rsaKey *Key, ed *Key) {
+	t.Helper()
+
+	hmac, err := NewHMACKey("hmac", []byte("a-test-secret-of-at-least-32-bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err = NewRSAKey("rsa", rsaPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed, err = NewEd25519Key("ed", edPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hmac, rsaKey, ed
+}
+
+func TestSignAndVerify(t *testing.T) {
+	hmac, rsaKey, ed := newTestKeys(t)
+
+	for _, k := range []*Key{hmac, rsaKey, ed} {
+		p, err := NewKeyProvider(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signed, err := p.Sign(newTestClaims())
+		if err != nil {
+			t.Fatalf("%v: %v", k.Algorithm, err)
+		}
+
+		token, err := p.VerifyToken(signed)
+		if err != nil {
+			t.Fatalf("%v: %v", k.Algorithm, err)
+		}
+
+		username, _ := token.Get("username")
+		if username != "angelo" {
+			t.Errorf("%v: got username %v", k.Algorithm, username)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	hmac, rsaKey, ed := newTestKeys(t)
+
+	before, err := NewKeyProvider(hmac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := before.Sign(newTestClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	during, err := NewKeyProvider(ed, hmac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := during.VerifyToken(signed); err != nil {
+		t.Errorf("token signed with the previous key: %v", err)
+	}
+
+	after, err := NewKeyProvider(ed, rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := after.VerifyToken(signed); err == nil {
+		t.Error("expected an error for a token signed with a retired key")
+	}
+}
+
+func TestVerifyRejectsForeignAndExpiredTokens(t *testing.T) {
+	hmac, _, _ := newTestKeys(t)
+	p, err := NewKeyProvider(hmac)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same key ID, different secret
+	impostor, err := NewHMACKey("hmac", []byte("some other secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostorProvider, err := NewKeyProvider(impostor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := impostorProvider.Sign(newTestClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.VerifyToken(forged); err == nil {
+		t.Error("expected an error for a forged token")
+	}
+
+	claims := newTestClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	expired, err := p.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.VerifyToken(expired); err != jwtauth.ErrExpired {
+		t.Errorf("got %v, want ErrExpired", err)
+	}
+}
+
+func TestNewKeyProviderRejectsDuplicateIDs(t *testing.T) {
+	hmac, _, _ := newTestKeys(t)
+
+	if _, err := NewKeyProvider(hmac, hmac); err == nil {
+		t.Error("expected an error for duplicate key IDs")
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	k, err := ParsePrivateKey("ed", AlgEdDSA, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Algorithm != AlgEdDSA {
+		t.Errorf("got algorithm %v", k.Algorithm)
+	}
+
+	if _, err := ParsePrivateKey("ed", AlgRS256, data); err == nil {
+		t.Error("expected an error for an Ed25519 key used for RS256")
+	}
+}
+
+func TestJWKSOnlyHasPublicKeys(t *testing.T) {
+	hmac, rsaKey, ed := newTestKeys(t)
+	p, err := NewKeyProvider(ed, rsaKey, hmac)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	NewHandler(p).JWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	var jwks struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("got %v keys, want the RSA and Ed25519 keys only", len(jwks.Keys))
+	}
+	for _, k := range jwks.Keys {
+		// Private and symmetric key material
+		for _, field := range []string{"d", "p", "q", "k"} {
+			if _, ok := k[field]; ok {
+				t.Errorf("key %v leaks %q", k["kid"], field)
+			}
+		}
+	}
+}
+
+func TestVerifierSetsContext(t *testing.T) {
+	hmac, _, _ := newTestKeys(t)
+	p, err := NewKeyProvider(hmac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := p.Sign(newTestClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims *JwtClaims
+	h := p.Verifier()(jwtauth.Authenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err = JwtClaimsFromRequest(r)
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "jwt", Value: signed})
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if err != nil || claims == nil || claims.Username != "angelo" {
+		t.Fatalf("got claims %+v, error %v", claims, err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %v without a token, want 401", w.Code)
+	}
+}
@@ -0,0 +1,126 @@
+// middleware.go provides middleware for routes that need a logged in user
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/angelofallars/htmx-go"
+	"github.com/go-chi/jwtauth/v5"
+)
+
+// Only let through requests with a valid JWT, for use after a Verifier.
+//
+// Browsers are sent to the login page, which sends them back to where they
+// were after logging in. Other clients get a 401.
+func RequireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _, err := jwtauth.FromContext(r.Context())
+		if err == nil && token != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch {
+		case htmx.IsHTMXRequest(r):
+			// htmx drops the body of a 401, but still follows the redirect
+			htmx.NewResponse().
+				Redirect(LoginURL(returnURL(r))).
+				StatusCode(http.StatusUnauthorized).
+				Write(w)
+		case r.Header.Get("Authorization") == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+			http.Redirect(w, r, LoginURL(r.URL.RequestURI()), http.StatusSeeOther)
+		default:
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+	})
+}
+
+// Only let through requests with a valid JWT, for use after a Verifier,
+// responding to others with a JSON error.
+func RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _, err := jwtauth.FromContext(r.Context())
+		if err == nil && token != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		svc.WriteJSONError(w, svc.ErrUnauthorized)
+	})
+}
+
+// Only let through requests whose token has the scope their method needs,
+// read for GET and HEAD and write for anything else, for use after
+// RequireToken.
+func RequireMethodScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := JwtClaimsFromRequest(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			svc.WriteJSONError(w, svc.ErrUnauthorized)
+			return
+		}
+
+		scope := ScopeWrite
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			scope = ScopeRead
+		}
+
+		if !claims.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+string(scope)+`"`)
+			svc.WriteJSONErrorCode(w, http.StatusForbidden, ErrInsufficientScope)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The page to go back to after logging in
+func returnURL(r *http.Request) string {
+	// A boosted request is the browser navigating to a page
+	if htmx.IsBoosted(r) && r.Method == http.MethodGet {
+		return r.URL.RequestURI()
+	}
+
+	// htmx.GetCurrentURL misses the header once net/http canonicalizes it
+	currentURL := r.Header.Get(htmx.HeaderCurrentURL)
+	if currentURL == "" {
+		return "/"
+	}
+
+	u, err := url.Parse(currentURL)
+	if err != nil {
+		return "/"
+	}
+	return u.RequestURI()
+}
+
+// The login page, which goes to next after logging in
+func LoginURL(next string) string {
+	next = SafeNext(next)
+	if next == "/" {
+		return "/login"
+	}
+	return "/login?next=" + url.QueryEscape(next)
+}
+
+// Only allow redirecting to a page on this site, so a link to the login
+// page can't send the user to another site. Anything else becomes "/".
+func SafeNext(next string) string {
+	// "//example.com" and "/\example.com" lead to other sites in browsers
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "/"
+	}
+
+	return next
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/angelofallars/htmx-go"
+	"github.com/go-chi/jwtauth/v5"
+	jwxjwt "github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+func TestRequireLogin(t *testing.T) {
+	h := RequireLogin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a request without a token was let through")
+	}))
+
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		headers      map[string]string
+		wantCode     int
+		wantLocation string
+		wantRedirect string
+	}{
+		{
+			name:         "full page",
+			method:       http.MethodGet,
+			target:       "/lists/1?filter=overdue",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/login?next=%2Flists%2F1%3Ffilter%3Doverdue",
+		},
+		{
+			name:   "htmx",
+			method: http.MethodPut,
+			target: "/items/1/toggle",
+			headers: map[string]string{
+				htmx.HeaderRequest:    "true",
+				htmx.HeaderCurrentURL: "http://localhost:3000/lists/1",
+			},
+			wantCode:     http.StatusUnauthorized,
+			wantRedirect: "/login?next=%2Flists%2F1",
+		},
+		{
+			name:   "boosted",
+			method: http.MethodGet,
+			target: "/lists",
+			headers: map[string]string{
+				htmx.HeaderRequest:    "true",
+				htmx.HeaderBoosted:    "true",
+				htmx.HeaderCurrentURL: "http://localhost:3000/",
+			},
+			wantCode:     http.StatusUnauthorized,
+			wantRedirect: "/login?next=%2Flists",
+		},
+		{
+			name:     "bearer token",
+			method:   http.MethodGet,
+			target:   "/lists",
+			headers:  map[string]string{"Authorization": "Bearer expired"},
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.wantCode {
+			t.Errorf("%v: got status %v, want %v", tt.name, w.Code, tt.wantCode)
+		}
+		if got := w.Header().Get("Location"); got != tt.wantLocation {
+			t.Errorf("%v: got Location %q, want %q", tt.name, got, tt.wantLocation)
+		}
+		if got := w.Header().Get(htmx.HeaderRedirect); got != tt.wantRedirect {
+			t.Errorf("%v: got HX-Redirect %q, want %q", tt.name, got, tt.wantRedirect)
+		}
+	}
+}
+
+func TestRequireMethodScope(t *testing.T) {
+	h := RequireMethodScope(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name     string
+		method   string
+		scope    string
+		wantCode int
+	}{
+		{"login token", http.MethodDelete, "", http.StatusNoContent},
+		{"read", http.MethodGet, "read", http.StatusNoContent},
+		{"read only", http.MethodPost, "read", http.StatusForbidden},
+		{"write includes read", http.MethodGet, "write", http.StatusNoContent},
+		{"write", http.MethodPatch, "write", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwxjwt.New()
+			token.Set("id", "user")
+			token.Set("username", "angelo")
+			if tt.scope != "" {
+				token.Set("scope", tt.scope)
+			}
+
+			r := httptest.NewRequest(tt.method, "/lists", nil)
+			r = r.WithContext(jwtauth.NewContext(r.Context(), token, nil))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("got status %v, want %v", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	tests := map[string]string{
+		"":                        "/",
+		"/lists/1?filter=overdue": "/lists/1?filter=overdue",
+		"lists":                   "/",
+		"//evil.example":          "/",
+		"/\\evil.example":         "/",
+		"https://evil.example/":   "/",
+		"javascript:alert(1)":     "/",
+	}
+
+	for next, want := range tests {
+		if got := SafeNext(next); got != want {
+			t.Errorf("SafeNext(%q): got %q, want %q", next, got, want)
+		}
+	}
+}
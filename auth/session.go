@@ -0,0 +1,307 @@
+// session.go provides login sessions, made of short-lived access tokens
+// and rotating refresh tokens
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/angelofallars/htmx-chi-todo/config"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	jwxjwt "github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// Requests sent at the same time may all try to refresh with the same
+// token, so reusing a token this soon does not end the session
+const refreshTokenReuseGrace = 10 * time.Second
+
+var (
+	ErrTokenRevoked        = errors.New("token has been revoked")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// A refresh token was used twice, so it may have been stolen
+	ErrRefreshTokenReused = errors.New("refresh token has already been used")
+)
+
+type (
+	TokenPair struct {
+		AccessToken           string
+		AccessTokenExpiresAt  time.Time
+		RefreshToken          string
+		RefreshTokenExpiresAt time.Time
+	}
+
+	// A refresh token as it is stored, which is only by its hash
+	RefreshToken struct {
+		Hash string
+		// Shared by every refresh token rotated from the same login
+		SessionID string
+		UserID    string
+		Username  string
+		ExpiresAt time.Time
+		UsedAt    time.Time
+	}
+
+	TokenStore interface {
+		CreateRefreshToken(ctx context.Context, t *RefreshToken) error
+		// Mark a refresh token as used and return it. If it was already used,
+		// it is returned along with ErrRefreshTokenReused.
+		UseRefreshToken(ctx context.Context, hash string, at time.Time) (*RefreshToken, error)
+		DeleteSession(ctx context.Context, sessionID string) error
+		RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+		// Revoke every token of the user issued at or before the given time
+		RevokeUser(ctx context.Context, userID string, at time.Time) error
+		IsRevoked(ctx context.Context, jti string, userID string, issuedAt time.Time) (bool, error)
+
+		CreateAPIToken(ctx context.Context, t *APIToken) error
+		// The user's API tokens, newest first
+		GetAPITokens(ctx context.Context, userID string) ([]*APIToken, error)
+		// Record that an API token was used and return it, with the current
+		// username of its user
+		UseAPIToken(ctx context.Context, hash string, at time.Time) (*APIToken, error)
+		DeleteAPIToken(ctx context.Context, userID string, id string) error
+		DeleteAPITokens(ctx context.Context, userID string) error
+	}
+
+	Sessions interface {
+		// Log in a user on a new device
+		Start(ctx context.Context, userID string, username string) (*TokenPair, error)
+		// Exchange a refresh token for a new pair of tokens
+		Refresh(ctx context.Context, refreshToken string) (*TokenPair, error)
+		// Verify an access token, including that it hasn't been revoked
+		Verify(ctx context.Context, accessToken string) (jwxjwt.Token, error)
+		// Log out the device holding the tokens, either of which may be empty
+		End(ctx context.Context, accessToken string, refreshToken string) error
+		// Log out the user on every device. API tokens keep working, since
+		// scripts don't log in again.
+		EndAll(ctx context.Context, userID string) error
+		// Middleware that verifies the JWT of a request, refreshing it if a
+		// browser's has expired, for use with jwtauth.Authenticator
+		Verifier() func(http.Handler) http.Handler
+		// Like Verifier, but only for tokens sent in the Authorization
+		// header, ignoring cookies. API tokens are accepted too.
+		BearerVerifier() func(http.Handler) http.Handler
+
+		// Make an API token for the user. It is only returned here, since
+		// only its hash is kept.
+		CreateAPIToken(ctx context.Context, userID string, name string, scopes []Scope) (string, *APIToken, error)
+		GetAPITokens(ctx context.Context, userID string) ([]*APIToken, error)
+		RevokeAPIToken(ctx context.Context, userID string, tokenID string) error
+		RevokeAPITokens(ctx context.Context, userID string) error
+	}
+
+	sessions struct {
+		keys    KeyProvider
+		store   TokenStore
+		cookies Cookies
+		cfg     config.Auth
+	}
+)
+
+func NewSessions(keys KeyProvider, store TokenStore, cookies Cookies, cfg config.Auth) Sessions {
+	return &sessions{
+		keys:    keys,
+		store:   store,
+		cookies: cookies,
+		cfg:     cfg,
+	}
+}
+
+func (s sessions) Start(ctx context.Context, userID string, username string) (*TokenPair, error) {
+	return s.issue(ctx, uuid.NewString(), userID, username)
+}
+
+func (s sessions) issue(ctx context.Context, sessionID string, userID string, username string) (*TokenPair, error) {
+	now := time.Now()
+
+	pair := &TokenPair{
+		AccessTokenExpiresAt:  now.Add(s.cfg.AccessTokenTTL.Duration),
+		RefreshTokenExpiresAt: now.Add(s.cfg.RefreshTokenTTL.Duration),
+	}
+
+	claims := &JwtClaims{
+		ID:        userID,
+		Username:  username,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(pair.AccessTokenExpiresAt),
+		},
+	}
+
+	accessToken, err := s.keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.store.CreateRefreshToken(ctx, &RefreshToken{
+		Hash:      hashToken(refreshToken),
+		SessionID: sessionID,
+		UserID:    userID,
+		Username:  username,
+		ExpiresAt: pair.RefreshTokenExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pair.AccessToken = accessToken
+	pair.RefreshToken = refreshToken
+
+	return pair, nil
+}
+
+// Refresh and API tokens, which are only stored by their hash
+func newRandomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s sessions) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	now := time.Now()
+
+	t, err := s.store.UseRefreshToken(ctx, hashToken(refreshToken), now)
+	if errors.Is(err, ErrRefreshTokenReused) && now.Sub(t.UsedAt) > refreshTokenReuseGrace {
+		// Whoever holds the other copy of the token must lose access too
+		return nil, errors.Join(err, s.store.DeleteSession(ctx, t.SessionID))
+	} else if err != nil && !errors.Is(err, ErrRefreshTokenReused) {
+		return nil, err
+	}
+
+	if now.After(t.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return s.issue(ctx, t.SessionID, t.UserID, t.Username)
+}
+
+func (s sessions) Verify(ctx context.Context, accessToken string) (jwxjwt.Token, error) {
+	t, err := s.keys.VerifyToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens without an ID can't be revoked, and tokens with an audience are
+	// for something other than logging in
+	if t.JwtID() == "" || len(t.Audience()) != 0 {
+		return nil, jwtauth.ErrUnauthorized
+	}
+
+	userID, _ := t.PrivateClaims()["id"].(string)
+
+	revoked, err := s.store.IsRevoked(ctx, t.JwtID(), userID, t.IssuedAt())
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	return t, nil
+}
+
+func (s sessions) End(ctx context.Context, accessToken string, refreshToken string) error {
+	errs := make([]error, 0)
+
+	// An expired access token needs no revoking
+	if t, err := s.keys.VerifyToken(accessToken); err == nil {
+		errs = append(errs, s.store.RevokeAccessToken(ctx, t.JwtID(), t.Expiration()))
+
+		if sessionID, ok := t.PrivateClaims()["sid"].(string); ok {
+			errs = append(errs, s.store.DeleteSession(ctx, sessionID))
+		}
+	}
+
+	if refreshToken != "" {
+		t, err := s.store.UseRefreshToken(ctx, hashToken(refreshToken), time.Now())
+		if t != nil {
+			errs = append(errs, s.store.DeleteSession(ctx, t.SessionID))
+		} else if !errors.Is(err, ErrInvalidRefreshToken) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s sessions) EndAll(ctx context.Context, userID string) error {
+	return s.store.RevokeUser(ctx, userID, time.Now())
+}
+
+func (s sessions) Verifier() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			var token jwxjwt.Token
+			err := jwtauth.ErrNoTokenFound
+
+			// Clients sending their token in a header refresh it themselves
+			accessToken := jwtauth.TokenFromHeader(r)
+			isBrowser := accessToken == ""
+			if isBrowser {
+				accessToken = jwtauth.TokenFromCookie(r)
+			}
+
+			if accessToken != "" {
+				token, err = s.Verify(ctx, accessToken)
+			}
+
+			if err != nil && isBrowser {
+				if cookie, cookieErr := r.Cookie(RefreshTokenCookie); cookieErr == nil {
+					pair, refreshErr := s.Refresh(ctx, cookie.Value)
+					if refreshErr == nil {
+						s.cookies.SetSession(w, pair)
+						token, err = s.keys.VerifyToken(pair.AccessToken)
+					}
+				}
+			}
+
+			ctx = jwtauth.NewContext(ctx, token, err)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func (s sessions) BearerVerifier() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			var token jwxjwt.Token
+			err := jwtauth.ErrNoTokenFound
+
+			accessToken := jwtauth.TokenFromHeader(r)
+			if isAPIToken(accessToken) {
+				token, err = s.verifyAPIToken(ctx, accessToken)
+			} else if accessToken != "" {
+				token, err = s.Verify(ctx, accessToken)
+			}
+
+			ctx = jwtauth.NewContext(ctx, token, err)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/angelofallars/htmx-chi-todo/config"
+	"github.com/angelofallars/htmx-chi-todo/migrate"
+	"github.com/go-chi/jwtauth/v5"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestSessions(t *testing.T) (Sessions, *SQLiteTokenStore) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to ":memory:" opens a separate database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, migrate.Migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	hmac, err := NewHMACKey("hmac", []byte("a-test-secret-of-at-least-32-bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeyProvider(hmac)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewSQLiteTokenStore(db)
+	return NewSessions(keys, store, NewCookies(true), config.Default().Auth), store
+}
+
+func TestSessionRefreshRotatesTokens(t *testing.T) {
+	s, store := newTestSessions(t)
+	ctx := context.Background()
+
+	first, err := s.Start(ctx, "user", "angelo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(ctx, first.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := s.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("the refresh token was not rotated")
+	}
+
+	// As if the first token was used long ago, and someone stole a copy
+	if _, err := store.UseRefreshToken(ctx, hashToken(second.RefreshToken), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Refresh(ctx, second.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("got %v, want ErrRefreshTokenReused", err)
+	}
+
+	// The whole session ends, including the latest token of the real owner
+	if _, err := s.Refresh(ctx, first.RefreshToken); err == nil {
+		t.Error("expected the session to have ended")
+	}
+}
+
+func TestSessionRefreshAllowsConcurrentReuse(t *testing.T) {
+	s, _ := newTestSessions(t)
+	ctx := context.Background()
+
+	pair, err := s.Start(ctx, "user", "angelo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Refresh(ctx, pair.RefreshToken); err != nil {
+			t.Fatalf("refresh %v: %v", i, err)
+		}
+	}
+}
+
+func TestSessionEnd(t *testing.T) {
+	s, _ := newTestSessions(t)
+	ctx := context.Background()
+
+	pair, err := s.Start(ctx, "user", "angelo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.Start(ctx, "user", "angelo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.End(ctx, pair.AccessToken, pair.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Verify(ctx, pair.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("got %v, want ErrTokenRevoked", err)
+	}
+	if _, err := s.Refresh(ctx, pair.RefreshToken); err == nil {
+		t.Error("expected an error refreshing a logged out session")
+	}
+
+	// Other devices stay logged in
+	if _, err := s.Verify(ctx, other.AccessToken); err != nil {
+		t.Errorf("other device: %v", err)
+	}
+
+	// Logging out twice is fine
+	if err := s.End(ctx, pair.AccessToken, pair.RefreshToken); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSessionEndAll(t *testing.T) {
+	s, _ := newTestSessions(t)
+	ctx := context.Background()
+
+	pairs := make([]*TokenPair, 0)
+	for i := 0; i < 2; i++ {
+		pair, err := s.Start(ctx, "user", "angelo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs = append(pairs, pair)
+	}
+	someoneElse, err := s.Start(ctx, "someone else", "htmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.EndAll(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pair := range pairs {
+		if _, err := s.Verify(ctx, pair.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+			t.Errorf("got %v, want ErrTokenRevoked", err)
+		}
+		if _, err := s.Refresh(ctx, pair.RefreshToken); err == nil {
+			t.Error("expected an error refreshing after logging out everywhere")
+		}
+	}
+
+	if _, err := s.Verify(ctx, someoneElse.AccessToken); err != nil {
+		t.Errorf("another user: %v", err)
+	}
+}
+
+func TestSessionVerifierRefreshesBrowsers(t *testing.T) {
+	s, _ := newTestSessions(t)
+
+	pair, err := s.Start(context.Background(), "user", "angelo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims *JwtClaims
+	h := s.Verifier()(jwtauth.Authenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err = JwtClaimsFromRequest(r)
+	})))
+
+	// The access token cookie is gone once it expires
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: RefreshTokenCookie, Value: pair.RefreshToken})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if err != nil || claims == nil || claims.Username != "angelo" {
+		t.Fatalf("got claims %+v, error %v", claims, err)
+	}
+
+	cookies := make(map[string]*http.Cookie)
+	for _, c := range w.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+	for _, name := range []string{AccessTokenCookie, RefreshTokenCookie} {
+		c, ok := cookies[name]
+		if !ok || c.Value == "" {
+			t.Errorf("got no %v cookie, want a new token", name)
+			continue
+		}
+		if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.Path != "/" {
+			t.Errorf("%v cookie is missing flags: %+v", name, c)
+		}
+	}
+	if !cookies[RefreshTokenCookie].Expires.After(cookies[AccessTokenCookie].Expires) {
+		t.Error("the refresh token cookie should outlive the access token cookie")
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	s, store := newTestSessions(t)
+	ctx := context.Background()
+
+	// API tokens take the username from the users table
+	_, err := store.db.Exec(`INSERT INTO users( id, username, password, email, createdAt )
+							 values( 'user', 'angelo', '', 'angelo@example.com', 0 )`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _, err := s.CreateAPIToken(ctx, "user", "backup script", []Scope{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims *JwtClaims
+	h := s.BearerVerifier()(jwtauth.Authenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err = JwtClaimsFromRequest(r)
+	})))
+	request := func() int {
+		claims = nil
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := request(); code != http.StatusOK || err != nil {
+		t.Fatalf("got status %v, error %v", code, err)
+	}
+	if claims.ID != "user" || claims.Username != "angelo" {
+		t.Errorf("got claims %+v, want the user's", claims)
+	}
+	if !claims.HasScope(ScopeRead) || claims.HasScope(ScopeWrite) {
+		t.Errorf("got scope %q, want only read", claims.Scope)
+	}
+
+	tokens, err := s.GetAPITokens(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "backup script" || tokens[0].LastUsedAt.IsZero() {
+		t.Fatalf("got tokens %+v, want the one used", tokens)
+	}
+
+	// Logging out everywhere leaves API tokens alone
+	if err := s.EndAll(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if code := request(); code != http.StatusOK {
+		t.Errorf("after logging out everywhere: got status %v", code)
+	}
+
+	if err := s.RevokeAPIToken(ctx, "someone else", tokens[0].ID); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Errorf("revoking another user's token: got %v, want ErrInvalidAPIToken", err)
+	}
+	if err := s.RevokeAPIToken(ctx, "user", tokens[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := request(); code != http.StatusUnauthorized {
+		t.Errorf("revoked token: got status %v, want 401", code)
+	}
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type SQLiteTokenStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteTokenStore(db *sql.DB) *SQLiteTokenStore {
+	return &SQLiteTokenStore{
+		db: db,
+	}
+}
+
+func (s SQLiteTokenStore) CreateRefreshToken(ctx context.Context, t *RefreshToken) error {
+	// Keep the table from growing with tokens that were never used
+	_, err := s.db.Exec(`DELETE FROM refreshTokens WHERE userId = ? AND expiresAt < ?`,
+		t.UserID,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT INTO refreshTokens( hash, sessionId, userId, username, expiresAt )
+						 values( ?, ?, ?, ?, ? )`,
+		t.Hash,
+		t.SessionID,
+		t.UserID,
+		t.Username,
+		t.ExpiresAt.Unix(),
+	)
+	return err
+}
+
+func (s SQLiteTokenStore) UseRefreshToken(ctx context.Context, hash string, at time.Time) (*RefreshToken, error) {
+	// Only one request can mark the token as used
+	result, err := s.db.Exec(`UPDATE refreshTokens SET usedAt = ? WHERE hash = ? AND usedAt IS NULL`,
+		at.Unix(),
+		hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	row := s.db.QueryRow(`SELECT sessionId, userId, username, expiresAt, usedAt
+						  FROM refreshTokens
+						  WHERE hash = ?`, hash)
+
+	t := &RefreshToken{Hash: hash}
+	var expiresAt int64
+	var usedAt sql.NullInt64
+	err = row.Scan(&t.SessionID, &t.UserID, &t.Username, &expiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidRefreshToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	t.ExpiresAt = time.Unix(expiresAt, 0)
+	if usedAt.Valid {
+		t.UsedAt = time.Unix(usedAt.Int64, 0)
+	}
+
+	if affected == 0 {
+		return t, ErrRefreshTokenReused
+	}
+
+	return t, nil
+}
+
+func (s SQLiteTokenStore) DeleteSession(ctx context.Context, sessionID string) error {
+	_, err := s.db.Exec(`DELETE FROM refreshTokens WHERE sessionId = ?`, sessionID)
+	return err
+}
+
+func (s SQLiteTokenStore) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	// Expired tokens are rejected anyway
+	_, err := s.db.Exec(`DELETE FROM revokedTokens WHERE expiresAt < ?`, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT OR IGNORE INTO revokedTokens( jti, expiresAt ) values( ?, ? )`,
+		jti,
+		expiresAt.Unix(),
+	)
+	return err
+}
+
+func (s SQLiteTokenStore) RevokeUser(ctx context.Context, userID string, at time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO userRevocations( userId, revokedAt ) values( ?, ? )
+					  ON CONFLICT(userId) DO UPDATE SET revokedAt = excluded.revokedAt`,
+		userID,
+		at.Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM refreshTokens WHERE userId = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s SQLiteTokenStore) IsRevoked(ctx context.Context, jti string, userID string, issuedAt time.Time) (bool, error) {
+	// Token times only have seconds, so a token issued in the same second
+	// as a revocation counts as revoked
+	row := s.db.QueryRow(`SELECT
+							EXISTS(SELECT 1 FROM revokedTokens WHERE jti = ?)
+							OR EXISTS(SELECT 1 FROM userRevocations WHERE userId = ? AND revokedAt >= ?)`,
+		jti,
+		userID,
+		issuedAt.Unix(),
+	)
+
+	var revoked bool
+	err := row.Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+func (s SQLiteTokenStore) CreateAPIToken(ctx context.Context, t *APIToken) error {
+	_, err := s.db.Exec(`INSERT INTO apiTokens( id, hash, userId, name, scopes, createdAt )
+						 values( ?, ?, ?, ?, ?, ? )`,
+		t.ID,
+		t.Hash,
+		t.UserID,
+		t.Name,
+		joinScopes(t.Scopes),
+		t.CreatedAt.Unix(),
+	)
+	return err
+}
+
+func (s SQLiteTokenStore) GetAPITokens(ctx context.Context, userID string) ([]*APIToken, error) {
+	rows, err := s.db.Query(`SELECT id, hash, userId, name, scopes, createdAt, lastUsedAt
+							 FROM apiTokens
+							 WHERE userId = ?
+							 ORDER BY createdAt DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*APIToken, 0)
+	for rows.Next() {
+		t := &APIToken{}
+		var scopes string
+		var createdAt int64
+		var lastUsedAt sql.NullInt64
+		err := rows.Scan(&t.ID, &t.Hash, &t.UserID, &t.Name, &scopes, &createdAt, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		t.Scopes = splitScopes(scopes)
+		t.CreatedAt = time.Unix(createdAt, 0)
+		if lastUsedAt.Valid {
+			t.LastUsedAt = time.Unix(lastUsedAt.Int64, 0)
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (s SQLiteTokenStore) UseAPIToken(ctx context.Context, hash string, at time.Time) (*APIToken, error) {
+	result, err := s.db.Exec(`UPDATE apiTokens SET lastUsedAt = ? WHERE hash = ?`, at.Unix(), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrInvalidAPIToken
+	}
+
+	// The users table belongs to the user package, but the username is
+	// needed for the claims. Tokens of deleted users are not found.
+	row := s.db.QueryRow(`SELECT apiTokens.id, apiTokens.userId, users.username, apiTokens.name,
+							apiTokens.scopes, apiTokens.createdAt
+						  FROM apiTokens
+						  JOIN users ON users.id = apiTokens.userId
+						  WHERE apiTokens.hash = ?`, hash)
+
+	t := &APIToken{Hash: hash, LastUsedAt: time.Unix(at.Unix(), 0)}
+	var scopes string
+	var createdAt int64
+	err = row.Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &scopes, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidAPIToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	t.Scopes = splitScopes(scopes)
+	t.CreatedAt = time.Unix(createdAt, 0)
+
+	return t, nil
+}
+
+func (s SQLiteTokenStore) DeleteAPIToken(ctx context.Context, userID string, id string) error {
+	// Only the owner can delete a token
+	result, err := s.db.Exec(`DELETE FROM apiTokens WHERE id = ? AND userId = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvalidAPIToken
+	}
+
+	return nil
+}
+
+func (s SQLiteTokenStore) DeleteAPITokens(ctx context.Context, userID string) error {
+	_, err := s.db.Exec(`DELETE FROM apiTokens WHERE userId = ?`, userID)
+	return err
+}
@@ -0,0 +1,342 @@
+// config.go provides the application configuration, loaded from an optional
+// JSON file and environment variables
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	EnvDev        = "dev"
+	EnvProduction = "production"
+
+	StoreRedis  = "redis"
+	StoreSQLite = "sqlite"
+
+	EventBusMemory = "memory"
+	EventBusRedis  = "redis"
+
+	// Only allowed in dev mode
+	DefaultJWTSecret = "secret"
+)
+
+type (
+	Config struct {
+		// Either EnvDev or EnvProduction
+		Env string `json:"env"`
+		// Address for the HTTP server to listen on
+		Addr string `json:"addr"`
+		// Where users reach the site, for links in emails
+		BaseURL    string `json:"baseURL"`
+		SQLitePath string `json:"sqlitePath"`
+		// Where todo lists are stored, either StoreRedis or StoreSQLite
+		TodoStore string `json:"todoStore"`
+		// How changes to todo lists reach the other tabs showing them, either
+		// EventBusMemory, or EventBusRedis to reach other instances too
+		EventBus string `json:"eventBus"`
+		Redis    Redis  `json:"redis"`
+		Auth     Auth   `json:"auth"`
+		Mail     Mail   `json:"mail"`
+	}
+
+	Redis struct {
+		Addr     string `json:"addr"`
+		Password string `json:"password"`
+		DB       int    `json:"db"`
+	}
+
+	Auth struct {
+		// Signs tokens with HS256 when there are no JWTKeys
+		JWTSecret string `json:"jwtSecret"`
+		// The first key signs new tokens, the rest only verify tokens signed
+		// before the keys were rotated
+		JWTKeys []JWTKey `json:"jwtKeys"`
+		// How long a token is accepted without checking its refresh token
+		AccessTokenTTL Duration `json:"accessTokenTTL"`
+		// How long a login lasts without being used
+		RefreshTokenTTL Duration `json:"refreshTokenTTL"`
+	}
+
+	// Emails are sent over SMTP if there is an SMTP host, otherwise they are
+	// written to LogFile, or stderr, for local development
+	Mail struct {
+		From string `json:"from"`
+		// Without an SMTP host, emails are written to LogFile instead
+		SMTPHost     string `json:"smtpHost"`
+		SMTPPort     int    `json:"smtpPort"`
+		SMTPUsername string `json:"smtpUsername"`
+		SMTPPassword string `json:"smtpPassword"`
+		// Standard error if empty
+		LogFile string `json:"logFile"`
+	}
+
+	JWTKey struct {
+		// Sent as the "kid" in the token header
+		ID string `json:"id"`
+		// Either HS256, RS256 or EdDSA
+		Algorithm string `json:"algorithm"`
+		// Only for HS256
+		Secret string `json:"secret"`
+		// PEM file of the private key, only for RS256 and EdDSA
+		PrivateKeyFile string `json:"privateKeyFile"`
+	}
+)
+
+// A time.Duration written as a string like "72h" in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func Default() *Config {
+	return &Config{
+		Env:        EnvDev,
+		Addr:       ":3000",
+		BaseURL:    "http://localhost:3000",
+		SQLitePath: "sqlite.db",
+		TodoStore:  StoreRedis,
+		EventBus:   EventBusMemory,
+		Redis: Redis{
+			Addr: "localhost:6379",
+			DB:   0,
+		},
+		Auth: Auth{
+			JWTSecret:       DefaultJWTSecret,
+			AccessTokenTTL:  Duration{time.Minute * 15},
+			RefreshTokenTTL: Duration{time.Hour * 72},
+		},
+		Mail: Mail{
+			From:     "htmx-chi-todo <noreply@localhost>",
+			SMTPPort: 587,
+		},
+	}
+}
+
+// Load the configuration, starting from the defaults, then applying the
+// config file at path if there is one, then the environment variables.
+//
+// If path is empty, the file named by TODO_CONFIG_FILE is used, if set.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv("TODO_CONFIG_FILE")
+	}
+
+	if path != "" {
+		err := cfg.loadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading config file %v: %w", path, err)
+		}
+	}
+
+	err := cfg.loadEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(cfg)
+}
+
+func (cfg *Config) loadEnv() error {
+	strings := map[string]*string{
+		"TODO_ENV":            &cfg.Env,
+		"TODO_ADDR":           &cfg.Addr,
+		"TODO_BASE_URL":       &cfg.BaseURL,
+		"TODO_SQLITE_PATH":    &cfg.SQLitePath,
+		"TODO_STORE":          &cfg.TodoStore,
+		"TODO_EVENT_BUS":      &cfg.EventBus,
+		"TODO_REDIS_ADDR":     &cfg.Redis.Addr,
+		"TODO_REDIS_PASSWORD": &cfg.Redis.Password,
+		"TODO_JWT_SECRET":     &cfg.Auth.JWTSecret,
+		"TODO_MAIL_FROM":      &cfg.Mail.From,
+		"TODO_SMTP_HOST":      &cfg.Mail.SMTPHost,
+		"TODO_SMTP_USERNAME":  &cfg.Mail.SMTPUsername,
+		"TODO_SMTP_PASSWORD":  &cfg.Mail.SMTPPassword,
+		"TODO_MAIL_LOG_FILE":  &cfg.Mail.LogFile,
+	}
+
+	for name, field := range strings {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
+	}
+
+	ints := map[string]*int{
+		"TODO_REDIS_DB":  &cfg.Redis.DB,
+		"TODO_SMTP_PORT": &cfg.Mail.SMTPPort,
+	}
+
+	for name, field := range ints {
+		if value, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%v: %w", name, err)
+			}
+			*field = n
+		}
+	}
+
+	durations := map[string]*Duration{
+		"TODO_ACCESS_TOKEN_TTL":  &cfg.Auth.AccessTokenTTL,
+		"TODO_REFRESH_TOKEN_TTL": &cfg.Auth.RefreshTokenTTL,
+	}
+
+	for name, field := range durations {
+		if value, ok := os.LookupEnv(name); ok {
+			err := field.UnmarshalText([]byte(value))
+			if err != nil {
+				return fmt.Errorf("%v: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check that the configuration is usable, and safe outside of dev mode.
+func (cfg *Config) Validate() error {
+	errs := make([]error, 0)
+
+	if cfg.Env != EnvDev && cfg.Env != EnvProduction {
+		errs = append(errs, fmt.Errorf("env must be %q or %q", EnvDev, EnvProduction))
+	}
+
+	if cfg.Addr == "" {
+		errs = append(errs, errors.New("addr must not be empty"))
+	}
+
+	if u, err := url.Parse(cfg.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, errors.New("baseURL must be an http or https URL"))
+	}
+
+	if cfg.SQLitePath == "" {
+		errs = append(errs, errors.New("sqlitePath must not be empty"))
+	}
+
+	if cfg.TodoStore != StoreRedis && cfg.TodoStore != StoreSQLite {
+		errs = append(errs, fmt.Errorf("todoStore must be %q or %q", StoreRedis, StoreSQLite))
+	}
+
+	if cfg.EventBus != EventBusMemory && cfg.EventBus != EventBusRedis {
+		errs = append(errs, fmt.Errorf("eventBus must be %q or %q", EventBusMemory, EventBusRedis))
+	}
+
+	if (cfg.TodoStore == StoreRedis || cfg.EventBus == EventBusRedis) && cfg.Redis.Addr == "" {
+		errs = append(errs, errors.New("redis.addr must not be empty"))
+	}
+
+	if cfg.Redis.DB < 0 {
+		errs = append(errs, errors.New("redis.db must not be negative"))
+	}
+
+	if len(cfg.Auth.JWTKeys) == 0 {
+		errs = append(errs, cfg.validateSecret("auth.jwtSecret", cfg.Auth.JWTSecret)...)
+	}
+
+	keyIDs := make(map[string]bool)
+	for i, k := range cfg.Auth.JWTKeys {
+		name := fmt.Sprintf("auth.jwtKeys[%v]", i)
+
+		if k.ID == "" {
+			errs = append(errs, fmt.Errorf("%v.id must not be empty", name))
+		} else if keyIDs[k.ID] {
+			errs = append(errs, fmt.Errorf("%v.id %q is used by another key", name, k.ID))
+		}
+		keyIDs[k.ID] = true
+
+		switch k.Algorithm {
+		case "HS256":
+			errs = append(errs, cfg.validateSecret(name+".secret", k.Secret)...)
+		case "RS256", "EdDSA":
+			if k.PrivateKeyFile == "" {
+				errs = append(errs, fmt.Errorf("%v.privateKeyFile must not be empty", name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%v.algorithm must be HS256, RS256 or EdDSA", name))
+		}
+	}
+
+	if _, err := mail.ParseAddress(cfg.Mail.From); err != nil {
+		errs = append(errs, fmt.Errorf("mail.from: %w", err))
+	}
+
+	if cfg.Mail.SMTPHost == "" && cfg.Env != EnvDev {
+		errs = append(errs, errors.New("mail.smtpHost must be set outside of dev mode, or users can't verify their email"))
+	}
+
+	if cfg.Mail.SMTPHost != "" && (cfg.Mail.SMTPPort <= 0 || cfg.Mail.SMTPPort > 65535) {
+		errs = append(errs, errors.New("mail.smtpPort must be a port number"))
+	}
+
+	if cfg.Auth.AccessTokenTTL.Duration <= 0 {
+		errs = append(errs, errors.New("auth.accessTokenTTL must be positive"))
+	}
+
+	if cfg.Auth.RefreshTokenTTL.Duration < cfg.Auth.AccessTokenTTL.Duration {
+		errs = append(errs, errors.New("auth.refreshTokenTTL must not be shorter than auth.accessTokenTTL"))
+	}
+
+	if len(errs) != 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+func (cfg *Config) validateSecret(name string, secret string) []error {
+	if secret == "" {
+		return []error{fmt.Errorf("%v must not be empty", name)}
+	}
+
+	if cfg.Env != EnvDev {
+		if secret == DefaultJWTSecret {
+			return []error{fmt.Errorf("%v must be changed from the default outside of dev mode", name)}
+		} else if len(secret) < 32 {
+			return []error{fmt.Errorf("%v must be at least 32 bytes outside of dev mode", name)}
+		}
+	}
+
+	return nil
+}
+
+func (cfg *Config) IsDev() bool {
+	return cfg.Env == EnvDev
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("got %+v, want the defaults %+v", cfg, Default())
+	}
+}
+
+func TestLoadFileThenEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{
+		"addr": ":8080",
+		"todoStore": "sqlite",
+		"redis": {"addr": "redis:6379", "db": 2},
+		"auth": {"refreshTokenTTL": "24h"}
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TODO_ADDR", ":9090")
+	t.Setenv("TODO_REDIS_DB", "3")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Addr != ":9090" {
+		t.Errorf("got addr %q, want the environment to win over the file", cfg.Addr)
+	}
+	if cfg.TodoStore != StoreSQLite {
+		t.Errorf("got todo store %q, want %q", cfg.TodoStore, StoreSQLite)
+	}
+	if cfg.Redis.Addr != "redis:6379" || cfg.Redis.DB != 3 {
+		t.Errorf("got redis %+v", cfg.Redis)
+	}
+	if cfg.Auth.RefreshTokenTTL.Duration != 24*time.Hour {
+		t.Errorf("got refresh token TTL %v, want 24h", cfg.Auth.RefreshTokenTTL)
+	}
+	if cfg.SQLitePath != Default().SQLitePath {
+		t.Errorf("got sqlite path %q, want the default", cfg.SQLitePath)
+	}
+}
+
+func TestLoadRejectsUnknownFileFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"port": 3000}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func TestProductionRequiresSecret(t *testing.T) {
+	t.Setenv("TODO_ENV", EnvProduction)
+	t.Setenv("TODO_SMTP_HOST", "smtp.example.com")
+
+	if _, err := Load(""); err == nil {
+		t.Error("expected an error for the default secret in production")
+	}
+
+	t.Setenv("TODO_JWT_SECRET", "too short")
+	if _, err := Load(""); err == nil {
+		t.Error("expected an error for a short secret in production")
+	}
+
+	t.Setenv("TODO_JWT_SECRET", "a-production-secret-of-at-least-32-bytes")
+	if _, err := Load(""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+	}{
+		{"unknown env", func(cfg *Config) { cfg.Env = "staging" }},
+		{"empty addr", func(cfg *Config) { cfg.Addr = "" }},
+		{"relative base URL", func(cfg *Config) { cfg.BaseURL = "/todo" }},
+		{"invalid mail sender", func(cfg *Config) { cfg.Mail.From = "nobody" }},
+		{"no SMTP host in production", func(cfg *Config) {
+			cfg.Env = EnvProduction
+			cfg.Auth.JWTSecret = "a-production-secret-of-at-least-32-bytes"
+		}},
+		{"unknown todo store", func(cfg *Config) { cfg.TodoStore = "postgres" }},
+		{"empty redis addr", func(cfg *Config) { cfg.Redis.Addr = "" }},
+		{"unknown event bus", func(cfg *Config) { cfg.EventBus = "kafka" }},
+		{"redis event bus without redis", func(cfg *Config) {
+			cfg.TodoStore = StoreSQLite
+			cfg.EventBus = EventBusRedis
+			cfg.Redis.Addr = ""
+		}},
+		{"negative redis db", func(cfg *Config) { cfg.Redis.DB = -1 }},
+		{"empty secret", func(cfg *Config) { cfg.Auth.JWTSecret = "" }},
+		{"zero access token TTL", func(cfg *Config) { cfg.Auth.AccessTokenTTL.Duration = 0 }},
+		{"refresh token TTL shorter than access token TTL", func(cfg *Config) {
+			cfg.Auth.RefreshTokenTTL.Duration = time.Minute
+		}},
+		{"key without ID", func(cfg *Config) {
+			cfg.Auth.JWTKeys = []JWTKey{{Algorithm: "HS256", Secret: "secret"}}
+		}},
+		{"duplicate key IDs", func(cfg *Config) {
+			cfg.Auth.JWTKeys = []JWTKey{
+				{ID: "a", Algorithm: "HS256", Secret: "secret"},
+				{ID: "a", Algorithm: "HS256", Secret: "secret"},
+			}
+		}},
+		{"unknown key algorithm", func(cfg *Config) {
+			cfg.Auth.JWTKeys = []JWTKey{{ID: "a", Algorithm: "none"}}
+		}},
+		{"asymmetric key without file", func(cfg *Config) {
+			cfg.Auth.JWTKeys = []JWTKey{{ID: "a", Algorithm: "EdDSA"}}
+		}},
+	}
+
+	for _, tt := range tests {
+		cfg := Default()
+		tt.modify(cfg)
+
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%v: expected an error", tt.name)
+		}
+	}
+}
@@ -4,23 +4,25 @@ go 1.21.4
 
 require (
 	github.com/a-h/templ v0.2.476
+	github.com/angelofallars/htmx-go v0.0.0-20231122080018-50a7faf56d18
 	github.com/go-chi/chi/v5 v5.0.10
+	github.com/go-chi/jwtauth/v5 v5.1.1
 	github.com/go-playground/validator/v10 v10.16.0
 	github.com/golang-jwt/jwt/v5 v5.1.0
 	github.com/google/uuid v1.4.0
+	github.com/lestrrat-go/jwx/v2 v2.0.11
+	github.com/mattn/go-sqlite3 v1.14.18
 	github.com/redis/go-redis/v9 v9.3.0
 	github.com/unrolled/render v1.6.1
 	golang.org/x/crypto v0.15.0
 )
 
 require (
-	github.com/angelofallars/htmx-go v0.0.0-20231122080018-50a7faf56d18 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/fsnotify/fsnotify v1.7.0 // indirect
 	github.com/gabriel-vasile/mimetype v1.4.2 // indirect
-	github.com/go-chi/jwtauth/v5 v5.1.1 // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/goccy/go-json v0.10.2 // indirect
@@ -29,9 +31,7 @@ require (
 	github.com/lestrrat-go/httpcc v1.0.1 // indirect
 	github.com/lestrrat-go/httprc v1.0.4 // indirect
 	github.com/lestrrat-go/iter v1.0.2 // indirect
-	github.com/lestrrat-go/jwx/v2 v2.0.11 // indirect
 	github.com/lestrrat-go/option v1.0.1 // indirect
-	github.com/mattn/go-sqlite3 v1.14.18 // indirect
 	github.com/segmentio/asm v1.2.0 // indirect
 	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sys v0.14.0 // indirect
@@ -0,0 +1,111 @@
+// Package mail provides sending emails to users.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/angelofallars/htmx-chi-todo/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	// Plain text
+	Body string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Format a message as an email with headers.
+func (msg Message) bytes(from *netmail.Address) ([]byte, error) {
+	to, err := netmail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %v\r\n", from.String())
+	fmt.Fprintf(&b, "To: %v\r\n", to.String())
+	fmt.Fprintf(&b, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&b, "\r\n%v\r\n", msg.Body)
+
+	return b.Bytes(), nil
+}
+
+type SMTPMailer struct {
+	cfg config.Mail
+}
+
+func NewSMTPMailer(cfg config.Mail) *SMTPMailer {
+	return &SMTPMailer{
+		cfg: cfg,
+	}
+}
+
+func (m SMTPMailer) Send(ctx context.Context, msg Message) error {
+	from, err := netmail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return err
+	}
+
+	body, err := msg.bytes(from)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", m.cfg.SMTPUsername, m.cfg.SMTPPassword, m.cfg.SMTPHost)
+	}
+
+	to, _ := netmail.ParseAddress(msg.To)
+	addr := m.cfg.SMTPHost + ":" + strconv.Itoa(m.cfg.SMTPPort)
+
+	return smtp.SendMail(addr, auth, from.Address, []string{to.Address}, body)
+}
+
+// Writes emails to w instead of sending them, for local development.
+type LogMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+func NewLogMailer(w io.Writer, from string) *LogMailer {
+	return &LogMailer{
+		w:    w,
+		from: from,
+	}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	from, err := netmail.ParseAddress(m.from)
+	if err != nil {
+		return err
+	}
+
+	body, err := msg.bytes(from)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err = fmt.Fprintf(m.w, "----- email -----\r\n%s----- end of email -----\r\n", body)
+	return err
+}
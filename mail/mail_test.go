@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer(t *testing.T) {
+	var b bytes.Buffer
+	m := NewLogMailer(&b, "htmx-chi-todo <noreply@example.com>")
+
+	err := m.Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "Verify your email address",
+		Body:    "Open the link below.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"From: \"htmx-chi-todo\" <noreply@example.com>\r\n",
+		"To: <alice@example.com>\r\n",
+		"Subject: Verify your email address\r\n",
+		"\r\n\r\nOpen the link below.\r\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("got email %q, want it to contain %q", b.String(), want)
+		}
+	}
+}
+
+func TestSendRejectsInvalidRecipient(t *testing.T) {
+	m := NewLogMailer(&bytes.Buffer{}, "noreply@example.com")
+
+	err := m.Send(context.Background(), Message{To: "not an address\r\nBcc: mallory@example.com"})
+	if err == nil {
+		t.Error("got no error, want header injection to be rejected")
+	}
+}
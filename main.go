@@ -1,59 +1,203 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"time"
+	// Due dates are shown in the time zone of the user's browser
+	_ "time/tzdata"
 
+	"github.com/angelofallars/htmx-chi-todo/auth"
+	"github.com/angelofallars/htmx-chi-todo/config"
+	"github.com/angelofallars/htmx-chi-todo/mail"
+	"github.com/angelofallars/htmx-chi-todo/migrate"
+	"github.com/angelofallars/htmx-chi-todo/openapi"
+	"github.com/angelofallars/htmx-chi-todo/ratelimit"
+	"github.com/angelofallars/htmx-chi-todo/site"
 	"github.com/angelofallars/htmx-chi-todo/todo"
 	"github.com/angelofallars/htmx-chi-todo/user"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/jwtauth/v5"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/redis/go-redis/v9"
 )
 
-const sqliteFileName = "sqlite.db"
-
 func main() {
+	configFile := flag.String("config", "", "path to a JSON config file, overridden by TODO_* environment variables")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+
+	sqliteDB, err := sql.Open("sqlite3", cfg.SQLitePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	migrator, err := migrate.New(sqliteDB, migrate.Migrations)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if flag.Arg(0) == "migrate" {
+		err := runMigrateCommand(context.Background(), migrator, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	err = migrator.Up(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	redisClient := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-		DB:   0,
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
 	})
 
-	sqliteDB, err := sql.Open("sqlite3", sqliteFileName)
+	userSQLite3Repo := user.NewSQLiteRepository(sqliteDB)
+
+	var todoRepo todo.Repository
+	switch cfg.TodoStore {
+	case config.StoreRedis:
+		todoRepo = todo.NewRedisRepository(redisClient)
+	case config.StoreSQLite:
+		todoRepo = todo.NewSQLiteRepository(sqliteDB)
+	}
+
+	// Browsers only allow secure cookies over HTTPS, except on localhost
+	cookies := auth.NewCookies(!cfg.IsDev())
+
+	keys, err := auth.NewKeyProviderFromConfig(cfg.Auth)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	userSQLite3Repo := user.NewSQLiteRepository(sqliteDB)
+	sessions := auth.NewSessions(
+		keys,
+		auth.NewSQLiteTokenStore(sqliteDB),
+		cookies,
+		cfg.Auth,
+	)
+
+	rateLimitStore := ratelimit.NewFallbackStore(
+		ratelimit.NewRedisStore(redisClient),
+		ratelimit.NewMemoryStore(),
+	)
+
+	loginLimits := user.LoginLimits{
+		// Generous, since many people can share an IP address
+		ByIP: ratelimit.NewLimiter(rateLimitStore, ratelimit.Policy{
+			FreeFailures: 20,
+			BaseLockout:  time.Minute,
+			MaxLockout:   time.Hour,
+			Window:       time.Hour,
+		}),
+		ByUsername: ratelimit.NewLimiter(rateLimitStore, ratelimit.Policy{
+			FreeFailures: 5,
+			BaseLockout:  30 * time.Second,
+			MaxLockout:   time.Hour,
+			Window:       24 * time.Hour,
+		}),
+	}
+
+	mailer, err := newMailer(cfg.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var todoEvents todo.EventBus
+	switch cfg.EventBus {
+	case config.EventBusMemory:
+		todoEvents = todo.NewMemoryEventBus()
+	case config.EventBusRedis:
+		todoEvents = todo.NewRedisEventBus(redisClient)
+	}
+
+	todoService := todo.NewService(todoRepo, todoEvents, user.NewDirectory(userSQLite3Repo))
+
+	userService := user.NewService(
+		userSQLite3Repo,
+		user.NewSQLiteResetTokenStore(sqliteDB),
+		sessions,
+		user.Emails{
+			Keys:    keys,
+			Mailer:  mailer,
+			BaseURL: cfg.BaseURL,
+		},
+		loginLimits,
+		// Deleting an account deletes its todo lists
+		todoService,
+	)
 
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	user.NewHandler(
-		user.NewService(
-			userSQLite3Repo,
-		),
-	).Mount(r)
+	auth.NewHandler(keys).Mount(r)
 
-	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	// The API never reads cookies, so it needs no CSRF protection
+	api, apiDoc := newAPIRouter(sessions, userService, todoService)
+	r.Mount(apiPath, api)
+	r.Get("/api/openapi.json", openapi.Handler(apiDoc))
 
 	r.Group(func(r chi.Router) {
-		r.Use(jwtauth.Verifier(tokenAuth))
-		r.Use(jwtauth.Authenticator)
-
-		todo.NewHandler(
-			todo.NewService(
-				todo.NewRedisRepository(redisClient),
-			),
-		).Mount(r)
+		r.Use(site.CSRF(cookies))
+
+		// The user pages work logged out, but still need to know who is
+		// logged in
+		r.Group(func(r chi.Router) {
+			r.Use(sessions.Verifier())
+
+			user.NewHandler(
+				userService,
+				cookies,
+			).Mount(r)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(sessions.Verifier())
+			r.Use(auth.RequireLogin)
+
+			todo.NewHandler(
+				todoService,
+			).Mount(r)
+		})
 	})
 
 	r.Handle("/assets/*", http.StripPrefix("/assets/", http.FileServer(http.Dir("assets"))))
 
-	log.Fatal(http.ListenAndServe(":3000", r))
+	if cfg.IsDev() && len(cfg.Auth.JWTKeys) == 0 && cfg.Auth.JWTSecret == config.DefaultJWTSecret {
+		log.Print("warning: using the default JWT secret, only do this in development")
+	}
+
+	log.Printf("listening on %v", cfg.Addr)
+	log.Fatal(http.ListenAndServe(cfg.Addr, r))
+}
+
+// Send emails over SMTP if configured, otherwise write them to a log
+func newMailer(cfg config.Mail) (mail.Mailer, error) {
+	if cfg.SMTPHost != "" {
+		return mail.NewSMTPMailer(cfg), nil
+	}
+
+	if cfg.LogFile == "" {
+		return mail.NewLogMailer(os.Stderr, cfg.From), nil
+	}
+
+	f, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return mail.NewLogMailer(f, cfg.From), nil
 }
@@ -0,0 +1,286 @@
+// migrate.go provides versioned schema migrations for the SQLite database
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A single numbered schema change, with the SQL to apply and revert it.
+type Migration struct {
+	Version     int
+	Description string
+	Up          string
+	Down        string
+}
+
+// The state of a migration in the database.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	// Serializes migrations within this process, the database lock
+	// takes care of other processes.
+	mu sync.Mutex
+}
+
+var (
+	ErrUnknownVersion  = errors.New("unknown migration version")
+	ErrNoDownMigration = errors.New("migration cannot be reverted")
+)
+
+// Create a migrator for a set of migrations, which must have unique
+// positive versions.
+func New(db *sql.DB, migrations []Migration) (*Migrator, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	for i, m := range sorted {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("migration %q must have a positive version", m.Description)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicate migration version %v", m.Version)
+		}
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: sorted,
+	}, nil
+}
+
+// The version of the newest known migration.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// List every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			appliedAt, ok := applied[mg.Version]
+			statuses = append(statuses, Status{
+				Migration: mg,
+				Applied:   ok,
+				AppliedAt: appliedAt,
+			})
+		}
+		return nil
+	})
+
+	return statuses, err
+}
+
+// The version of the newest applied migration, or 0 if none are.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var version int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		version, err = currentVersion(ctx, conn)
+		return err
+	})
+
+	return version, err
+}
+
+// Apply every pending migration.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Revert the newest applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		version, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if version == 0 {
+			return nil
+		}
+
+		target := 0
+		for _, mg := range m.migrations {
+			if mg.Version < version {
+				target = mg.Version
+			}
+		}
+
+		return m.migrateTo(ctx, conn, version, target)
+	})
+}
+
+// Apply or revert migrations until the database is at the given version.
+func (m *Migrator) To(ctx context.Context, version int) error {
+	if version != 0 && !m.knows(version) {
+		return fmt.Errorf("%w: %v", ErrUnknownVersion, version)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		return m.migrateTo(ctx, conn, current, version)
+	})
+}
+
+func (m *Migrator) knows(version int) bool {
+	for _, mg := range m.migrations {
+		if mg.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Migrator) migrateTo(ctx context.Context, conn *sql.Conn, current int, target int) error {
+	if target >= current {
+		for _, mg := range m.migrations {
+			if mg.Version <= current || mg.Version > target {
+				continue
+			}
+
+			if _, err := conn.ExecContext(ctx, mg.Up); err != nil {
+				return fmt.Errorf("applying migration %v (%v): %w", mg.Version, mg.Description, err)
+			}
+
+			_, err := conn.ExecContext(ctx,
+				`INSERT INTO schema_migrations( version, description, appliedAt )
+				 values( ?, ?, ? )`,
+				mg.Version, mg.Description, time.Now().Unix(),
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mg := m.migrations[i]
+		if mg.Version > current || mg.Version <= target {
+			continue
+		}
+
+		if mg.Down == "" {
+			return fmt.Errorf("%w: %v (%v)", ErrNoDownMigration, mg.Version, mg.Description)
+		}
+
+		if _, err := conn.ExecContext(ctx, mg.Down); err != nil {
+			return fmt.Errorf("reverting migration %v (%v): %w", mg.Version, mg.Description, err)
+		}
+
+		_, err := conn.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, mg.Version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run fn inside a transaction holding SQLite's write lock, so that
+// concurrently starting instances migrate one at a time. The transaction is
+// rolled back if fn fails.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Wait for other instances holding the lock instead of failing
+	// immediately with SQLITE_BUSY
+	if _, err := conn.ExecContext(ctx, `PRAGMA busy_timeout = 30000`); err != nil {
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			conn.ExecContext(context.Background(), `ROLLBACK`)
+			return
+		}
+		_, err = conn.ExecContext(ctx, `COMMIT`)
+	}()
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations(
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		appliedAt INTEGER NOT NULL
+	);
+	`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, appliedAt FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(appliedAt, 0)
+	}
+
+	return applied, rows.Err()
+}
+
+func currentVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	row := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+
+	var version int
+	err := row.Scan(&version)
+	return version, err
+}
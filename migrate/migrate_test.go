@@ -0,0 +1,145 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testMigrations = []Migration{
+	{
+		Version:     2,
+		Description: "create b",
+		Up:          `CREATE TABLE b(id INTEGER PRIMARY KEY);`,
+		Down:        `DROP TABLE b;`,
+	},
+	{
+		Version:     1,
+		Description: "create a",
+		Up:          `CREATE TABLE a(id INTEGER PRIMARY KEY);`,
+		Down:        `DROP TABLE a;`,
+	},
+	{
+		Version:     3,
+		Description: "broken",
+		Up:          `CREATE TABLE c(id INTEGER PRIMARY KEY); NOT SQL;`,
+		Down:        `DROP TABLE c;`,
+	},
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to ":memory:" opens a separate database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count == 1
+}
+
+func assertVersion(t *testing.T, m *Migrator, want int) {
+	t.Helper()
+
+	got, err := m.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("got version %v, want %v", got, want)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	m, err := New(db, testMigrations[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertVersion(t, m, 0)
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 2)
+	if !tableExists(t, db, "a") || !tableExists(t, db, "b") {
+		t.Fatal("expected tables a and b to exist")
+	}
+
+	if err := m.Down(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 1)
+	if tableExists(t, db, "b") {
+		t.Fatal("expected table b to be dropped")
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || !statuses[0].Applied || statuses[1].Applied {
+		t.Fatalf("got statuses %+v, want only version 1 applied", statuses)
+	}
+
+	if err := m.To(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 0)
+	if tableExists(t, db, "a") {
+		t.Fatal("expected table a to be dropped")
+	}
+
+	if err := m.To(ctx, 7); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("got error %v, want ErrUnknownVersion", err)
+	}
+}
+
+func TestMigratorRollsBackFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	m, err := New(db, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(ctx); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+
+	assertVersion(t, m, 0)
+	if tableExists(t, db, "a") || tableExists(t, db, "c") {
+		t.Fatal("expected the failed run to be rolled back")
+	}
+}
+
+func TestNewRejectsDuplicateVersions(t *testing.T) {
+	_, err := New(newTestDB(t), []Migration{
+		{Version: 1, Description: "one"},
+		{Version: 1, Description: "also one"},
+	})
+	if err == nil {
+		t.Fatal("expected duplicate versions to be rejected")
+	}
+}
@@ -0,0 +1,198 @@
+package migrate
+
+// The schema of the SQLite database. Append new migrations to the end with
+// the next version number, never edit one that has been released.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create users",
+		// IF NOT EXISTS adopts databases created before versioned migrations
+		Up: `
+		CREATE TABLE IF NOT EXISTS users(
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			password TEXT NOT NULL,
+			email TEXT NOT NULL UNIQUE,
+			createdAt INTEGER NOT NULL
+		);
+		`,
+		Down: `DROP TABLE users;`,
+	},
+	{
+		Version:     2,
+		Description: "create todo lists and items",
+		Up: `
+		CREATE TABLE IF NOT EXISTS lists(
+			id TEXT PRIMARY KEY,
+			ownerId TEXT NOT NULL,
+			createdAt INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS listsByOwner ON lists(ownerId, createdAt);
+
+		CREATE TABLE IF NOT EXISTS items(
+			id TEXT PRIMARY KEY,
+			listId TEXT NOT NULL,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL,
+			isDone INTEGER NOT NULL,
+			createdAt INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS itemsByList ON items(listId, createdAt);
+		`,
+		Down: `
+		DROP TABLE items;
+		DROP TABLE lists;
+		`,
+	},
+	{
+		Version:     3,
+		Description: "add owners to todo items",
+		Up: `
+		ALTER TABLE items ADD COLUMN ownerId TEXT NOT NULL DEFAULT '';
+		UPDATE items SET ownerId = COALESCE(
+			(SELECT lists.ownerId FROM lists WHERE lists.id = items.listId),
+			''
+		);
+		`,
+		Down: `ALTER TABLE items DROP COLUMN ownerId;`,
+	},
+	{
+		Version:     4,
+		Description: "add titles and archiving to todo lists",
+		Up: `
+		ALTER TABLE lists ADD COLUMN title TEXT NOT NULL DEFAULT 'Untitled list';
+		ALTER TABLE lists ADD COLUMN isArchived INTEGER NOT NULL DEFAULT 0;
+		`,
+		Down: `
+		ALTER TABLE lists DROP COLUMN isArchived;
+		ALTER TABLE lists DROP COLUMN title;
+		`,
+	},
+	{
+		Version:     5,
+		Description: "add manual ordering to todo items",
+		// Existing items are numbered in the order they were created
+		Up: `
+		ALTER TABLE items ADD COLUMN position REAL NOT NULL DEFAULT 0;
+		UPDATE items SET position = 1024 * (
+			SELECT COUNT(*) FROM items AS other
+			WHERE other.listId = items.listId
+			  AND (other.createdAt < items.createdAt
+			   OR (other.createdAt = items.createdAt AND other.rowid <= items.rowid))
+		);
+		CREATE INDEX itemsByPosition ON items(listId, position);
+		`,
+		Down: `
+		DROP INDEX itemsByPosition;
+		ALTER TABLE items DROP COLUMN position;
+		`,
+	},
+	{
+		Version:     6,
+		Description: "add due dates to todo items",
+		Up: `
+		ALTER TABLE items ADD COLUMN dueAt INTEGER;
+		ALTER TABLE items ADD COLUMN dueTimeZone TEXT NOT NULL DEFAULT '';
+		CREATE INDEX itemsByDueDate ON items(ownerId, dueAt);
+		`,
+		Down: `
+		DROP INDEX itemsByDueDate;
+		ALTER TABLE items DROP COLUMN dueTimeZone;
+		ALTER TABLE items DROP COLUMN dueAt;
+		`,
+	},
+	{
+		Version:     7,
+		Description: "add refresh tokens and token revocation",
+		Up: `
+		CREATE TABLE refreshTokens(
+			hash TEXT PRIMARY KEY,
+			sessionId TEXT NOT NULL,
+			userId TEXT NOT NULL,
+			username TEXT NOT NULL,
+			expiresAt INTEGER NOT NULL,
+			usedAt INTEGER
+		);
+		CREATE INDEX refreshTokensBySession ON refreshTokens(sessionId);
+		CREATE INDEX refreshTokensByUser ON refreshTokens(userId, expiresAt);
+
+		CREATE TABLE revokedTokens(
+			jti TEXT PRIMARY KEY,
+			expiresAt INTEGER NOT NULL
+		);
+
+		CREATE TABLE userRevocations(
+			userId TEXT PRIMARY KEY,
+			revokedAt INTEGER NOT NULL
+		);
+		`,
+		Down: `
+		DROP TABLE userRevocations;
+		DROP TABLE revokedTokens;
+		DROP TABLE refreshTokens;
+		`,
+	},
+	{
+		Version:     8,
+		Description: "add email verification to users",
+		// Users from before verification existed are counted as verified
+		Up: `
+		ALTER TABLE users ADD COLUMN emailVerifiedAt INTEGER;
+		UPDATE users SET emailVerifiedAt = createdAt;
+		`,
+		Down: `
+		ALTER TABLE users DROP COLUMN emailVerifiedAt;
+		`,
+	},
+	{
+		Version:     9,
+		Description: "add password reset tokens",
+		Up: `
+		CREATE TABLE passwordResetTokens(
+			hash TEXT PRIMARY KEY,
+			userId TEXT NOT NULL,
+			expiresAt INTEGER NOT NULL
+		);
+		CREATE INDEX passwordResetTokensByUser ON passwordResetTokens(userId);
+		`,
+		Down: `
+		DROP TABLE passwordResetTokens;
+		`,
+	},
+	{
+		Version:     10,
+		Description: "add personal API tokens",
+		Up: `
+		CREATE TABLE apiTokens(
+			id TEXT PRIMARY KEY,
+			hash TEXT NOT NULL UNIQUE,
+			userId TEXT NOT NULL,
+			name TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			createdAt INTEGER NOT NULL,
+			lastUsedAt INTEGER
+		);
+		CREATE INDEX apiTokensByUser ON apiTokens(userId, createdAt);
+		`,
+		Down: `
+		DROP TABLE apiTokens;
+		`,
+	},
+	{
+		Version:     11,
+		Description: "add list collaborators",
+		Up: `
+		CREATE TABLE listCollaborators(
+			listId TEXT NOT NULL,
+			userId TEXT NOT NULL,
+			role TEXT NOT NULL,
+			addedAt INTEGER NOT NULL,
+			PRIMARY KEY(listId, userId)
+		);
+		CREATE INDEX listCollaboratorsByUser ON listCollaborators(userId, addedAt);
+		`,
+		Down: `
+		DROP TABLE listCollaborators;
+		`,
+	},
+}
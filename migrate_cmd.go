@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/angelofallars/htmx-chi-todo/migrate"
+)
+
+const migrateUsage = `usage: htmx-chi-todo migrate <command>
+
+commands:
+  status   list migrations and whether they have been applied
+  up       apply all pending migrations
+  down     revert the newest applied migration
+  to N     apply or revert migrations until the schema is at version N`
+
+// Run the "migrate" subcommand.
+func runMigrateCommand(ctx context.Context, m *migrate.Migrator, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "status":
+		return printMigrationStatus(ctx, m)
+	case "up":
+		err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+	case "down":
+		err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+	case "to":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		err = m.To(ctx, version)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema is at version %v\n", version)
+
+	return nil
+}
+
+func printMigrationStatus(ctx context.Context, m *migrate.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED AT")
+	for _, s := range statuses {
+		appliedAt := "pending"
+		if s.Applied {
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", s.Version, s.Description, appliedAt)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,261 @@
+// Package openapi provides the OpenAPI 3 document describing the JSON API,
+// built from the routes each API handler describes.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+)
+
+const Version = "3.0.3"
+
+// A route of the JSON API, described next to where it is mounted.
+type Route struct {
+	Method string
+	// The chi pattern of the route, such as /lists/{id}
+	Path    string
+	Summary string
+	// Groups the route with others in the docs
+	Tag   string
+	Query []Param
+	// Zero values of the request and response bodies, nil if there is none
+	Request  any
+	Response any
+	// The status code of a success, 200 if zero
+	Status int
+	// Whether the route works without a bearer token
+	Public bool
+}
+
+// A string query parameter
+type Param struct {
+	Name        string
+	Description string
+}
+
+// Handlers that describe the routes they mount.
+type Describer interface {
+	Routes() []Route
+}
+
+type (
+	Document struct {
+		OpenAPI    string              `json:"openapi"`
+		Info       Info                `json:"info"`
+		Servers    []Server            `json:"servers,omitempty"`
+		Paths      map[string]PathItem `json:"paths"`
+		Components Components          `json:"components"`
+	}
+
+	Info struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	}
+
+	Server struct {
+		URL string `json:"url"`
+	}
+
+	// Operations by lowercase HTTP method
+	PathItem map[string]*Operation
+
+	Operation struct {
+		OperationID string                `json:"operationId"`
+		Summary     string                `json:"summary,omitempty"`
+		Tags        []string              `json:"tags,omitempty"`
+		Parameters  []Parameter           `json:"parameters,omitempty"`
+		RequestBody *RequestBody          `json:"requestBody,omitempty"`
+		Responses   map[string]Response   `json:"responses"`
+		Security    []map[string][]string `json:"security,omitempty"`
+	}
+
+	Parameter struct {
+		Name        string  `json:"name"`
+		In          string  `json:"in"`
+		Description string  `json:"description,omitempty"`
+		Required    bool    `json:"required,omitempty"`
+		Schema      *Schema `json:"schema"`
+	}
+
+	RequestBody struct {
+		Required bool                 `json:"required"`
+		Content  map[string]MediaType `json:"content"`
+	}
+
+	Response struct {
+		Description string               `json:"description"`
+		Content     map[string]MediaType `json:"content,omitempty"`
+	}
+
+	MediaType struct {
+		Schema *Schema `json:"schema"`
+	}
+
+	Components struct {
+		Schemas         map[string]*Schema        `json:"schemas"`
+		SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+	}
+
+	SecurityScheme struct {
+		Type        string `json:"type"`
+		Scheme      string `json:"scheme"`
+		Description string `json:"description,omitempty"`
+	}
+)
+
+const bearerAuth = "bearerAuth"
+
+var pathParamPattern = regexp.MustCompile(`\{([^}/]+)\}`)
+
+// Build the document of an API served at serverURL.
+func New(title string, version string, serverURL string, describers ...Describer) *Document {
+	doc := &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:   title,
+			Version: version,
+		},
+		Servers: []Server{{URL: serverURL}},
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			Schemas: make(map[string]*Schema),
+			SecuritySchemes: map[string]SecurityScheme{
+				bearerAuth: {
+					Type:        "http",
+					Scheme:      "bearer",
+					Description: "An access token from POST /token, or an API token made on the account page",
+				},
+			},
+		},
+	}
+	schemas := newSchemaGenerator(doc.Components.Schemas)
+
+	errorSchema := schemas.of(svc.ErrorResponse{})
+
+	for _, d := range describers {
+		for _, route := range d.Routes() {
+			if doc.Paths[route.Path] == nil {
+				doc.Paths[route.Path] = make(PathItem)
+			}
+			doc.Paths[route.Path][strings.ToLower(route.Method)] = newOperation(route, schemas, errorSchema)
+		}
+	}
+
+	return doc
+}
+
+func newOperation(route Route, schemas *schemaGenerator, errorSchema *Schema) *Operation {
+	op := &Operation{
+		OperationID: operationID(route),
+		Summary:     route.Summary,
+		Responses: map[string]Response{
+			"default": {
+				Description: "Error",
+				Content:     jsonContent(errorSchema),
+			},
+		},
+	}
+
+	if route.Tag != "" {
+		op.Tags = []string{route.Tag}
+	}
+	if !route.Public {
+		op.Security = []map[string][]string{{bearerAuth: {}}}
+	}
+
+	for _, match := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     match[1],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string", Format: "uuid"},
+		})
+	}
+	for _, p := range route.Query {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        p.Name,
+			In:          "query",
+			Description: p.Description,
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+
+	if route.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  jsonContent(schemas.of(route.Request)),
+		}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := Response{Description: http.StatusText(status)}
+	if route.Response != nil {
+		success.Content = jsonContent(schemas.of(route.Response))
+	}
+	op.Responses[strconv.Itoa(status)] = success
+
+	return op
+}
+
+func jsonContent(schema *Schema) map[string]MediaType {
+	return map[string]MediaType{
+		"application/json": {Schema: schema},
+	}
+}
+
+// Such as getListsById for GET /lists/{id}
+func operationID(route Route) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(route.Method))
+
+	for _, part := range strings.Split(route.Path, "/") {
+		if part == "" {
+			continue
+		}
+		if match := pathParamPattern.FindStringSubmatch(part); match != nil {
+			part = "by-" + match[1]
+		}
+		for _, word := range strings.Split(part, "-") {
+			if word != "" {
+				b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// Every route in the document, as "METHOD /path", sorted.
+func (doc *Document) Routes() []string {
+	routes := make([]string, 0)
+	for path, item := range doc.Paths {
+		for method := range item {
+			routes = append(routes, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// Serve the document as JSON.
+func Handler(doc *Document) http.HandlerFunc {
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		// Only Go values that can't be JSON, which is a bug
+		panic(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
@@ -0,0 +1,92 @@
+package openapi
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type testItem struct {
+	ID       uuid.UUID  `json:"id"`
+	Title    string     `json:"title"`
+	DueAt    *time.Time `json:"dueAt,omitempty"`
+	Tags     []string   `json:"tags"`
+	Children []testItem `json:"children"`
+	Secret   string     `json:"-"`
+	internal int
+}
+
+func TestSchema(t *testing.T) {
+	components := make(map[string]*Schema)
+	s := newSchemaGenerator(components).of(testItem{})
+
+	if s.Ref != "#/components/schemas/testItem" {
+		t.Fatalf("got ref %q", s.Ref)
+	}
+	item := components["testItem"]
+	if item == nil {
+		t.Fatal("testItem is missing from the components")
+	}
+
+	if id := item.Properties["id"]; id.Type != "string" || id.Format != "uuid" {
+		t.Errorf("got id schema %+v", id)
+	}
+	if dueAt := item.Properties["dueAt"]; dueAt.Type != "string" || dueAt.Format != "date-time" {
+		t.Errorf("got dueAt schema %+v", dueAt)
+	}
+	if tags := item.Properties["tags"]; tags.Type != "array" || tags.Items.Type != "string" {
+		t.Errorf("got tags schema %+v", tags)
+	}
+	if children := item.Properties["children"]; children.Items.Ref != s.Ref {
+		t.Errorf("got children schema %+v", children)
+	}
+	if _, ok := item.Properties["Secret"]; ok || len(item.Properties) != 5 {
+		t.Errorf("got properties %v", item.Properties)
+	}
+	if want := []string{"id", "title", "tags", "children"}; !slices.Equal(item.Required, want) {
+		t.Errorf("got required %v, want %v", item.Required, want)
+	}
+}
+
+type testDescriber []Route
+
+func (d testDescriber) Routes() []Route {
+	return d
+}
+
+func TestNew(t *testing.T) {
+	doc := New("test", "1.0.0", "/api", testDescriber{
+		{Method: http.MethodGet, Path: "/items/{id}", Response: testItem{}},
+		{Method: http.MethodDelete, Path: "/items/{id}", Status: http.StatusNoContent},
+		{Method: http.MethodPost, Path: "/token", Public: true},
+	})
+
+	want := []string{"DELETE /items/{id}", "GET /items/{id}", "POST /token"}
+	if got := doc.Routes(); !slices.Equal(got, want) {
+		t.Errorf("got routes %v, want %v", got, want)
+	}
+
+	get := doc.Paths["/items/{id}"]["get"]
+	if get.OperationID != "getItemsById" {
+		t.Errorf("got operation ID %q", get.OperationID)
+	}
+	if len(get.Parameters) != 1 || get.Parameters[0].Name != "id" || get.Parameters[0].In != "path" {
+		t.Errorf("got parameters %+v", get.Parameters)
+	}
+	if _, ok := get.Responses["200"]; !ok {
+		t.Errorf("got responses %v, want a 200", get.Responses)
+	}
+	if len(get.Security) != 1 {
+		t.Errorf("got security %v, want the bearer token", get.Security)
+	}
+
+	if _, ok := doc.Paths["/items/{id}"]["delete"].Responses["204"]; !ok {
+		t.Errorf("DELETE /items/{id} has no 204 response")
+	}
+	if token := doc.Paths["/token"]["post"]; token.Security != nil {
+		t.Errorf("got security %v on a public route", token.Security)
+	}
+}
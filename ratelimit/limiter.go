@@ -0,0 +1,116 @@
+// Package ratelimit provides limits on failed attempts, such as logins, with
+// a lockout that grows with every failure.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+)
+
+type Policy struct {
+	// Failures allowed before being locked out
+	FreeFailures int64
+	// The first lockout, which doubles with every failure after
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	// How long failures are remembered after the last one
+	Window time.Duration
+}
+
+// Returned when a key is locked out.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("Too many failed attempts, try again in %v.", e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockedError) Unwrap() error {
+	return svc.ErrTooManyRequests
+}
+
+type (
+	Limiter interface {
+		// Return a LockedError if key is locked out
+		Check(ctx context.Context, key string) error
+		// Count a failure for key, returning how long it is now locked
+		// out for, if at all
+		Fail(ctx context.Context, key string) (time.Duration, error)
+		// Forget the failures of key, such as after a success
+		Reset(ctx context.Context, key string) error
+	}
+
+	limiter struct {
+		store  Store
+		policy Policy
+	}
+)
+
+func NewLimiter(store Store, policy Policy) Limiter {
+	return &limiter{
+		store:  store,
+		policy: policy,
+	}
+}
+
+func failuresKey(key string) string {
+	return key + ":failures"
+}
+
+func lockKey(key string) string {
+	return key + ":lock"
+}
+
+func (l limiter) Check(ctx context.Context, key string) error {
+	ttl, err := l.store.LockTTL(ctx, lockKey(key))
+	if err != nil {
+		return err
+	}
+
+	if ttl > 0 {
+		return &LockedError{RetryAfter: ttl}
+	}
+	return nil
+}
+
+func (l limiter) Fail(ctx context.Context, key string) (time.Duration, error) {
+	failures, err := l.store.Increment(ctx, failuresKey(key), l.policy.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	lockout := l.policy.lockout(failures)
+	if lockout == 0 {
+		return 0, nil
+	}
+
+	err = l.store.Lock(ctx, lockKey(key), lockout)
+	if err != nil {
+		return 0, err
+	}
+
+	return lockout, nil
+}
+
+func (l limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Delete(ctx, failuresKey(key), lockKey(key))
+}
+
+// How long to lock out after the given number of failures
+func (p Policy) lockout(failures int64) time.Duration {
+	over := failures - p.FreeFailures
+	if over <= 0 {
+		return 0
+	}
+
+	lockout := p.BaseLockout
+	for i := int64(1); i < over && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+
+	return min(lockout, p.MaxLockout)
+}
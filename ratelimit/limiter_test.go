@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+)
+
+var testPolicy = Policy{
+	FreeFailures: 3,
+	BaseLockout:  time.Minute,
+	MaxLockout:   5 * time.Minute,
+	Window:       time.Hour,
+}
+
+func TestLimiterLocksOutExponentially(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), testPolicy)
+	ctx := context.Background()
+
+	want := []time.Duration{0, 0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, w := range want {
+		lockout, err := l.Fail(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lockout != w {
+			t.Errorf("failure %v: got lockout %v, want %v", i+1, lockout, w)
+		}
+	}
+
+	err := l.Check(ctx, "key")
+	var lockedErr *LockedError
+	if !errors.As(err, &lockedErr) || lockedErr.RetryAfter <= 4*time.Minute {
+		t.Errorf("got %v, want a lockout of about 5m", err)
+	}
+	if !errors.Is(err, svc.ErrTooManyRequests) {
+		t.Errorf("got %v, want ErrTooManyRequests", err)
+	}
+
+	if err := l.Check(ctx, "other key"); err != nil {
+		t.Errorf("other key: %v", err)
+	}
+
+	if err := l.Reset(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(ctx, "key"); err != nil {
+		t.Errorf("after reset: %v", err)
+	}
+	if lockout, _ := l.Fail(ctx, "key"); lockout != 0 {
+		t.Errorf("failures were not forgotten after reset, got lockout %v", lockout)
+	}
+}
+
+func TestMemoryStoreExpires(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	s.Increment(ctx, "counter", time.Minute)
+	s.Lock(ctx, "lock", time.Minute)
+
+	now = now.Add(30 * time.Second)
+	if count, _ := s.Increment(ctx, "counter", time.Minute); count != 2 {
+		t.Errorf("got count %v, want 2", count)
+	}
+	if ttl, _ := s.LockTTL(ctx, "lock"); ttl != 30*time.Second {
+		t.Errorf("got lock TTL %v, want 30s", ttl)
+	}
+
+	// Each increment pushes back the expiry
+	now = now.Add(45 * time.Second)
+	if count, _ := s.Increment(ctx, "counter", time.Minute); count != 3 {
+		t.Errorf("got count %v, want 3", count)
+	}
+	if ttl, _ := s.LockTTL(ctx, "lock"); ttl != 0 {
+		t.Errorf("got lock TTL %v, want the lock to have expired", ttl)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if count, _ := s.Increment(ctx, "counter", time.Minute); count != 1 {
+		t.Errorf("got count %v, want the counter to have expired", count)
+	}
+}
+
+type brokenStore struct{}
+
+var errBroken = errors.New("connection refused")
+
+func (brokenStore) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return 0, errBroken
+}
+
+func (brokenStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	return errBroken
+}
+
+func (brokenStore) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	return 0, errBroken
+}
+
+func (brokenStore) Delete(ctx context.Context, keys ...string) error {
+	return errBroken
+}
+
+func TestFallbackStore(t *testing.T) {
+	l := NewLimiter(NewFallbackStore(brokenStore{}, NewMemoryStore()), testPolicy)
+	ctx := context.Background()
+
+	for i := int64(0); i <= testPolicy.FreeFailures; i++ {
+		if _, err := l.Fail(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := l.Check(ctx, "key"); !errors.Is(err, svc.ErrTooManyRequests) {
+		t.Errorf("got %v, want the fallback to keep limiting", err)
+	}
+	if err := l.Reset(ctx, "key"); err != nil {
+		t.Errorf("reset: %v", err)
+	}
+}
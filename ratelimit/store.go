@@ -0,0 +1,217 @@
+// store.go provides where rate limits are kept track of
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Store interface {
+	// Add one to the counter at key and return the new count. The counter
+	// is deleted once ttl passes without another increment.
+	Increment(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Lock key until ttl passes
+	Lock(ctx context.Context, key string, ttl time.Duration) error
+	// How long until key is unlocked, zero if it isn't locked
+	LockTTL(ctx context.Context, key string) (time.Duration, error)
+	Delete(ctx context.Context, keys ...string) error
+}
+
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+func redisKey(key string) string {
+	return "ratelimit:" + key
+}
+
+func (s RedisStore) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, redisKey(key))
+		pipe.PExpire(ctx, redisKey(key), ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (s RedisStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.Set(ctx, redisKey(key), 1, ttl).Err()
+}
+
+func (s RedisStore) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, redisKey(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// Negative when the key doesn't exist or has no expiry
+	return max(ttl, 0), nil
+}
+
+func (s RedisStore) Delete(ctx context.Context, keys ...string) error {
+	redisKeys := make([]string, 0, len(keys))
+	for _, k := range keys {
+		redisKeys = append(redisKeys, redisKey(k))
+	}
+	return s.client.Del(ctx, redisKeys...).Err()
+}
+
+// Keeps the limits of a single server, which are lost when it restarts.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryEntry struct {
+	count     int64
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		now:     time.Now,
+	}
+}
+
+// Get an entry that hasn't expired. Must be called with s.mu held.
+func (s *MemoryStore) get(key string) (memoryEntry, bool) {
+	now := s.now()
+
+	// Every so often, forget everything that has expired
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if !now.Before(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	e, ok := s.entries[key]
+	if !ok || !now.Before(e.expiresAt) {
+		return memoryEntry{}, false
+	}
+	return e, true
+}
+
+func (s *MemoryStore) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, _ := s.get(key)
+	e.count++
+	e.expiresAt = s.now().Add(ttl)
+	s.entries[key] = e
+
+	return e.count, nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = memoryEntry{count: 1, expiresAt: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.get(key)
+	if !ok {
+		return 0, nil
+	}
+	return e.expiresAt.Sub(s.now()), nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range keys {
+		delete(s.entries, k)
+	}
+	return nil
+}
+
+type fallbackStore struct {
+	primary  Store
+	fallback Store
+}
+
+// Use the fallback store whenever the primary store fails, such as when
+// Redis is down, so logins keep working and stay limited.
+func NewFallbackStore(primary Store, fallback Store) Store {
+	return &fallbackStore{
+		primary:  primary,
+		fallback: fallback,
+	}
+}
+
+func (s fallbackStore) failed(op string, err error) {
+	log.Printf("rate limit store: %v failed, using fallback: %v", op, err)
+}
+
+func (s fallbackStore) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	count, err := s.primary.Increment(ctx, key, ttl)
+	if err != nil {
+		s.failed("increment", err)
+		return s.fallback.Increment(ctx, key, ttl)
+	}
+	return count, nil
+}
+
+func (s fallbackStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	err := s.primary.Lock(ctx, key, ttl)
+	if err != nil {
+		s.failed("lock", err)
+		return s.fallback.Lock(ctx, key, ttl)
+	}
+	return nil
+}
+
+func (s fallbackStore) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	// Locks set while the primary store was down are only in the fallback
+	fallbackTTL, err := s.fallback.LockTTL(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	ttl, err := s.primary.LockTTL(ctx, key)
+	if err != nil {
+		s.failed("lock TTL", err)
+		return fallbackTTL, nil
+	}
+	return max(ttl, fallbackTTL), nil
+}
+
+func (s fallbackStore) Delete(ctx context.Context, keys ...string) error {
+	// Locks may have been set in either store
+	err := s.fallback.Delete(ctx, keys...)
+	if err != nil {
+		return err
+	}
+
+	err = s.primary.Delete(ctx, keys...)
+	if err != nil {
+		s.failed("delete", err)
+	}
+	return nil
+}
@@ -1,7 +1,39 @@
 package service
 
-import "errors"
+import (
+	"errors"
+	"net/http"
+)
 
 // Validation error, meant to be combined with "errors.Join()" with
 // whatever validation error that occurred.
 var ErrValidation = errors.New("error validating input: ")
+
+var (
+	// The requested record does not exist.
+	ErrNotExists = errors.New("record does not exist")
+	// The record exists, but the acting user is not allowed to access it.
+	ErrForbidden = errors.New("you do not have access to this record")
+	// The action needs a logged in user.
+	ErrUnauthorized = errors.New("you need to be logged in")
+	// The action was tried too many times, and must wait.
+	ErrTooManyRequests = errors.New("too many attempts, try again later")
+)
+
+// Map an error returned by a service to the HTTP status code to respond with.
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotExists):
+		return http.StatusNotFound
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrTooManyRequests):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Request bodies bigger than this are rejected.
+const maxJSONBodySize = 1 << 20
+
+// The body of every JSON error response.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	// A short name for the kind of error, such as "validation".
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// The code in a JSON error body with the given HTTP status code.
+func ErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "validation"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusTooManyRequests:
+		return "too_many_requests"
+	default:
+		return "internal"
+	}
+}
+
+// Respond with v encoded as JSON.
+func WriteJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Respond with a JSON error body, with the status code given by StatusCode.
+func WriteJSONError(w http.ResponseWriter, err error) {
+	WriteJSONErrorCode(w, StatusCode(err), err)
+}
+
+// Respond with a JSON error body and the given status code.
+func WriteJSONErrorCode(w http.ResponseWriter, code int, err error) {
+	message := err.Error()
+	if code == http.StatusInternalServerError {
+		// Don't show clients the details of what went wrong
+		message = http.StatusText(code)
+	}
+
+	// Validation errors are joined after ErrValidation, whose message is
+	// only a prefix
+	message = strings.TrimSpace(strings.TrimPrefix(message, ErrValidation.Error()))
+
+	WriteJSON(w, code, ErrorResponse{
+		Error: ErrorBody{
+			Code:    ErrorCode(code),
+			Message: message,
+		},
+	})
+}
+
+// Decode a JSON request body into v, failing with ErrValidation if it is
+// malformed or has unknown fields.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodySize))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if errors.Is(err, io.EOF) {
+		return errors.Join(ErrValidation, errors.New("request body must not be empty"))
+	} else if err != nil {
+		return errors.Join(ErrValidation, fmt.Errorf("invalid request body: %w", err))
+	}
+
+	if dec.More() {
+		return errors.Join(ErrValidation, errors.New("request body must only have one JSON value"))
+	}
+
+	return nil
+}
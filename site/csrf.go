@@ -0,0 +1,117 @@
+// csrf.go provides protection against cross-site request forgery
+package site
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	// The errors template of this package takes the name
+	goerrors "errors"
+	"net/http"
+	"time"
+
+	"github.com/angelofallars/htmx-chi-todo/auth"
+	"github.com/go-chi/jwtauth/v5"
+)
+
+const (
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+	// For forms submitted without htmx
+	CSRFFormField = "csrf_token"
+)
+
+var ErrCSRF = goerrors.New("your session has expired, please reload the page")
+
+type csrfContextKey struct{}
+
+// Middleware that gives every browser session a CSRF token, and rejects
+// state-changing requests that don't send it back.
+//
+// Pages send the token back through the hx-headers on the <body>, which a
+// different site can't read.
+func CSRF(cookies auth.Cookies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := ""
+			if cookie, err := r.Cookie(CSRFCookie); err == nil {
+				token = cookie.Value
+			}
+
+			if !isSafeMethod(r.Method) && !isBearerRequest(r) {
+				sent := r.Header.Get(CSRFHeader)
+				if sent == "" {
+					sent = r.PostFormValue(CSRFFormField)
+				}
+
+				if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(sent)) != 1 {
+					RenderError(w, http.StatusForbidden, ErrCSRF)
+					return
+				}
+			}
+
+			if token == "" {
+				var err error
+				token, err = newCSRFToken()
+				if err != nil {
+					RenderError(w, http.StatusInternalServerError, err)
+					return
+				}
+				http.SetCookie(w, cookies.Cookie(CSRFCookie, token, time.Time{}))
+			}
+
+			ctx := context.WithValue(r.Context(), csrfContextKey{}, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// Browsers never add a bearer token on their own, so requests
+// authenticated by one can't be forged. They do add other schemes, such as
+// Basic behind a password protected staging site, and those requests are
+// still authenticated by the session cookie.
+func isBearerRequest(r *http.Request) bool {
+	return jwtauth.TokenFromHeader(r) != ""
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Get the CSRF token for the request, for use after the CSRF middleware
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+// The hx-headers attribute that makes htmx send the CSRF token
+func CSRFHeaders(r *http.Request) string {
+	headers, _ := json.Marshal(map[string]string{
+		CSRFHeader: CSRFToken(r),
+	})
+	return string(headers)
+}
+
+// Make the next page load get a new CSRF token, for when the user logs in
+// or out. This must be called before anything is written to w.
+func ResetCSRFToken(w http.ResponseWriter, cookies auth.Cookies) {
+	cookie := cookies.Cookie(CSRFCookie, "", time.Unix(0, 0))
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
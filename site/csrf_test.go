@@ -0,0 +1,108 @@
+package site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/angelofallars/htmx-chi-todo/auth"
+)
+
+func TestCSRF(t *testing.T) {
+	var token string
+	h := CSRF(auth.NewCookies(true))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r)
+	}))
+
+	// A first visit gets a token
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CSRFCookie || cookies[0].Value != token || token == "" {
+		t.Fatalf("got cookies %v and token %q, want a matching CSRF cookie", cookies, token)
+	}
+	cookie := cookies[0]
+
+	tests := []struct {
+		name     string
+		header   string
+		form     string
+		cookie   bool
+		wantCode int
+	}{
+		{"header", token, "", true, http.StatusOK},
+		{"form field", "", token, true, http.StatusOK},
+		{"no token", "", "", true, http.StatusForbidden},
+		{"wrong token", "forged", "", true, http.StatusForbidden},
+		{"no cookie", token, "", false, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		form := url.Values{}
+		if tt.form != "" {
+			form.Set(CSRFFormField, tt.form)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.header != "" {
+			r.Header.Set(CSRFHeader, tt.header)
+		}
+		if tt.cookie {
+			r.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.wantCode {
+			t.Errorf("%v: got status %v, want %v", tt.name, w.Code, tt.wantCode)
+		}
+	}
+}
+
+func TestCSRFSkipsBearerRequests(t *testing.T) {
+	h := CSRF(auth.NewCookies(true))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodDelete, "/items/1", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %v, want 200", w.Code)
+	}
+}
+
+func TestCSRFChecksBasicAuthRequests(t *testing.T) {
+	h := CSRF(auth.NewCookies(true))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// Sent by the browser itself behind HTTP Basic auth, so the session
+	// cookie is what authenticates the request
+	r := httptest.NewRequest(http.MethodDelete, "/items/1", nil)
+	r.SetBasicAuth("staging", "password")
+	r.AddCookie(&http.Cookie{Name: auth.AccessTokenCookie, Value: "session"})
+	r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: "token"})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %v, want 403", w.Code)
+	}
+}
+
+func TestResetCSRFToken(t *testing.T) {
+	w := httptest.NewRecorder()
+	ResetCSRFToken(w, auth.NewCookies(true))
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %v cookies, want 1", len(cookies))
+	}
+	c := cookies[0]
+	if c.Name != CSRFCookie || c.MaxAge != -1 || !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("got %+v, want the CSRF cookie cleared with the site's cookie flags", c)
+	}
+}
@@ -0,0 +1,540 @@
+// api.go provides the JSON API for todo lists, for clients other than the
+// browser
+package todo
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/angelofallars/htmx-chi-todo/openapi"
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// These are the DTOs (data transfer objects)
+type (
+	ListDTO struct {
+		ID         uuid.UUID `json:"id"`
+		Title      string    `json:"title"`
+		IsArchived bool      `json:"isArchived"`
+		CreatedAt  time.Time `json:"createdAt"`
+		// In order
+		Items []ItemDTO `json:"items"`
+	}
+
+	ItemDTO struct {
+		ID          uuid.UUID `json:"id"`
+		ListID      uuid.UUID `json:"listId"`
+		Title       string    `json:"title"`
+		Description string    `json:"description"`
+		IsDone      bool      `json:"isDone"`
+		CreatedAt   time.Time `json:"createdAt"`
+		// Absent if the item has no due date
+		DueAt *time.Time `json:"dueAt,omitempty"`
+		// The IANA time zone the due date is shown in
+		DueTimeZone string `json:"dueTimeZone,omitempty"`
+	}
+
+	ListsResponse struct {
+		Lists []ListDTO `json:"lists"`
+	}
+
+	ItemsResponse struct {
+		Items []ItemDTO `json:"items"`
+	}
+
+	CreateListReq struct {
+		Title string `json:"title"`
+	}
+
+	// Fields that are left out are not changed
+	UpdateListReq struct {
+		Title      *string `json:"title"`
+		IsArchived *bool   `json:"isArchived"`
+	}
+
+	CreateItemReq struct {
+		Title       string     `json:"title"`
+		Description string     `json:"description"`
+		DueAt       *time.Time `json:"dueAt"`
+		// The IANA time zone to show the due date in, UTC if empty
+		DueTimeZone string `json:"dueTimeZone"`
+	}
+
+	// Fields that are left out are not changed
+	UpdateItemReq struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		// A zero time clears the due date
+		DueAt       *time.Time `json:"dueAt"`
+		DueTimeZone string     `json:"dueTimeZone"`
+		IsDone      *bool      `json:"isDone"`
+	}
+
+	ReorderItemsReq struct {
+		// Every item of the list, in their new order
+		ItemIDs []uuid.UUID `json:"itemIds"`
+	}
+)
+
+func (l list) dto() ListDTO {
+	items := make([]ItemDTO, 0, len(l.Items))
+	for _, i := range l.Items {
+		items = append(items, i.dto())
+	}
+
+	return ListDTO{
+		ID:         l.ID,
+		Title:      l.Title,
+		IsArchived: l.IsArchived,
+		CreatedAt:  l.CreatedAt,
+		Items:      items,
+	}
+}
+
+func (i item) dto() ItemDTO {
+	dto := ItemDTO{
+		ID:          i.ID,
+		ListID:      i.ListID,
+		Title:       i.Title,
+		Description: i.Description,
+		IsDone:      bool(i.IsDone),
+		CreatedAt:   i.CreatedAt,
+	}
+	if i.hasDueDate() {
+		dueAt := i.DueAt.In(i.dueLocation())
+		dto.DueAt = &dueAt
+		dto.DueTimeZone = dueAt.Location().String()
+	}
+	return dto
+}
+
+// The due date of a request, shown in the given IANA time zone, or UTC
+func dueAtIn(dueAt time.Time, timeZone string) (time.Time, error) {
+	if timeZone == "" {
+		return dueAt.UTC(), nil
+	}
+
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.Time{}, errors.Join(svc.ErrValidation, errors.New("Unknown time zone"))
+	}
+	return dueAt.In(loc), nil
+}
+
+type (
+	APIHandler interface {
+		svc.HandlerMounter
+		openapi.Describer
+	}
+
+	apiHandler struct {
+		service Service
+	}
+)
+
+func NewAPIHandler(service Service) APIHandler {
+	return &apiHandler{
+		service: service,
+	}
+}
+
+func (h apiHandler) Mount(r chi.Router) {
+	r.Get("/lists", h.GetLists)
+	r.Post("/lists", h.CreateList)
+	r.Post("/lists/example", h.CreateExampleList)
+	r.Get("/lists/{id}", h.GetList)
+	r.Patch("/lists/{id}", h.UpdateList)
+	r.Delete("/lists/{id}", h.DeleteList)
+	r.Post("/lists/{id}/items", h.CreateItem)
+	r.Put("/lists/{id}/order", h.ReorderItems)
+	r.Get("/items/due", h.GetItemsDue)
+	r.Get("/items/{id}", h.GetItem)
+	r.Patch("/items/{id}", h.UpdateItem)
+	r.Delete("/items/{id}", h.DeleteItem)
+}
+
+func (h apiHandler) Routes() []openapi.Route {
+	return []openapi.Route{
+		{
+			Method: http.MethodGet, Path: "/lists", Tag: "lists",
+			Summary:  "Get the user's lists with their items, newest first",
+			Query:    []openapi.Param{{Name: "archived", Description: `"true" for the archived lists instead`}},
+			Response: ListsResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/lists", Tag: "lists",
+			Summary: "Create a list",
+			Request: CreateListReq{}, Response: ListDTO{}, Status: http.StatusCreated,
+		},
+		{
+			Method: http.MethodPost, Path: "/lists/example", Tag: "lists",
+			Summary:  "Create a list filled with example items",
+			Response: ListDTO{}, Status: http.StatusCreated,
+		},
+		{
+			Method: http.MethodGet, Path: "/lists/{id}", Tag: "lists",
+			Summary:  "Get a list with its items",
+			Response: ListDTO{},
+		},
+		{
+			Method: http.MethodPatch, Path: "/lists/{id}", Tag: "lists",
+			Summary: "Rename, archive or unarchive a list",
+			Request: UpdateListReq{}, Response: ListDTO{},
+		},
+		{
+			Method: http.MethodDelete, Path: "/lists/{id}", Tag: "lists",
+			Summary: "Delete a list with its items",
+			Status:  http.StatusNoContent,
+		},
+		{
+			Method: http.MethodPost, Path: "/lists/{id}/items", Tag: "items",
+			Summary: "Add an item to the end of a list",
+			Request: CreateItemReq{}, Response: ItemDTO{}, Status: http.StatusCreated,
+		},
+		{
+			Method: http.MethodPut, Path: "/lists/{id}/order", Tag: "items",
+			Summary: "Reorder the items of a list",
+			Request: ReorderItemsReq{}, Status: http.StatusNoContent,
+		},
+		{
+			Method: http.MethodGet, Path: "/items/due", Tag: "items",
+			Summary:  "Get the user's unfinished items that are overdue or due soon, soonest first",
+			Query:    []openapi.Param{{Name: "before", Description: "RFC 3339 time, a day from now by default"}},
+			Response: ItemsResponse{},
+		},
+		{
+			Method: http.MethodGet, Path: "/items/{id}", Tag: "items",
+			Summary:  "Get an item",
+			Response: ItemDTO{},
+		},
+		{
+			Method: http.MethodPatch, Path: "/items/{id}", Tag: "items",
+			Summary: "Edit or complete an item",
+			Request: UpdateItemReq{}, Response: ItemDTO{},
+		},
+		{
+			Method: http.MethodDelete, Path: "/items/{id}", Tag: "items",
+			Summary: "Delete an item",
+			Status:  http.StatusNoContent,
+		},
+	}
+}
+
+// Get the logged in user and the ID in the URL, writing an error if either
+// is missing
+func (h apiHandler) ids(w http.ResponseWriter, r *http.Request) (*uuid.UUID, *uuid.UUID, bool) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		svc.WriteJSONError(w, svc.ErrUnauthorized)
+		return nil, nil, false
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		svc.WriteJSONError(w, errors.Join(svc.ErrNotExists, err))
+		return nil, nil, false
+	}
+
+	return userID, &id, true
+}
+
+func (h apiHandler) GetLists(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		svc.WriteJSONError(w, svc.ErrUnauthorized)
+		return
+	}
+
+	archived := r.URL.Query().Get("archived") == "true"
+
+	lists, err := h.service.GetLists(r.Context(), userID, archived)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	res := ListsResponse{Lists: make([]ListDTO, 0, len(lists))}
+	for _, l := range lists {
+		res.Lists = append(res.Lists, l.dto())
+	}
+
+	svc.WriteJSON(w, http.StatusOK, res)
+}
+
+func (h apiHandler) CreateList(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		svc.WriteJSONError(w, svc.ErrUnauthorized)
+		return
+	}
+
+	var req CreateListReq
+	err = svc.DecodeJSON(w, r, &req)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	list := newList(*userID, req.Title)
+
+	_, err = h.service.CreateList(r.Context(), userID, list)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	svc.WriteJSON(w, http.StatusCreated, list.dto())
+}
+
+func (h apiHandler) CreateExampleList(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		svc.WriteJSONError(w, svc.ErrUnauthorized)
+		return
+	}
+
+	list, err := h.service.CreateExampleList(r.Context(), userID)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	svc.WriteJSON(w, http.StatusCreated, list.dto())
+}
+
+func (h apiHandler) GetList(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.ids(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := h.service.GetList(r.Context(), userID, id)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	svc.WriteJSON(w, http.StatusOK, list.dto())
+}
+
+func (h apiHandler) UpdateList(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.ids(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateListReq
+	err := svc.DecodeJSON(w, r, &req)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	list, err := h.service.GetList(r.Context(), userID, id)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	if req.Title != nil {
+		list, err = h.service.RenameList(r.Context(), userID, id, *req.Title)
+		if err != nil {
+			svc.WriteJSONError(w, err)
+			return
+		}
+	}
+
+	if req.IsArchived != nil {
+		list, err = h.service.SetListArchived(r.Context(), userID, id, *req.IsArchived)
+		if err != nil {
+			svc.WriteJSONError(w, err)
+			return
+		}
+	}
+
+	svc.WriteJSON(w, http.StatusOK, list.dto())
+}
+
+func (h apiHandler) DeleteList(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.ids(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.service.DeleteList(r.Context(), userID, id)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h apiHandler) CreateItem(w http.ResponseWriter, r *http.Request) {
+	userID, listID, ok := h.ids(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateItemReq
+	err := svc.DecodeJSON(w, r, &req)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	item := newItem(*listID, req.Title, req.Description)
+	if req.DueAt != nil {
+		dueAt, err := dueAtIn(*req.DueAt, req.DueTimeZone)
+		if err != nil {
+			svc.WriteJSONError(w, err)
+			return
+		}
+		item.setDue(dueAt)
+	}
+
+	_, err = h.service.CreateItem(r.Context(), userID, item)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	svc.WriteJSON(w, http.StatusCreated, item.dto())
+}
+
+func (h apiHandler) ReorderItems(w http.ResponseWriter, r *http.Request) {
+	userID, listID, ok := h.ids(w, r)
+	if !ok {
+		return
+	}
+
+	var req ReorderItemsReq
+	err := svc.DecodeJSON(w, r, &req)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	err = h.service.ReorderItems(r.Context(), userID, listID, req.ItemIDs)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Get the user's unfinished items due before the "before" query parameter,
+// which defaults to a day from now, including the overdue ones.
+func (h apiHandler) GetItemsDue(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		svc.WriteJSONError(w, svc.ErrUnauthorized)
+		return
+	}
+
+	before := time.Now().Add(24 * time.Hour)
+	if rawBefore := r.URL.Query().Get("before"); rawBefore != "" {
+		before, err = time.Parse(time.RFC3339, rawBefore)
+		if err != nil {
+			svc.WriteJSONError(w, errors.Join(svc.ErrValidation, errors.New("before must be an RFC 3339 time")))
+			return
+		}
+	}
+
+	items, err := h.service.GetItemsDue(r.Context(), userID, time.Unix(0, 0), before)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	res := ItemsResponse{Items: make([]ItemDTO, 0, len(items))}
+	for _, i := range items {
+		res.Items = append(res.Items, i.dto())
+	}
+
+	svc.WriteJSON(w, http.StatusOK, res)
+}
+
+func (h apiHandler) GetItem(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.ids(w, r)
+	if !ok {
+		return
+	}
+
+	item, err := h.service.GetItem(r.Context(), userID, id)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	svc.WriteJSON(w, http.StatusOK, item.dto())
+}
+
+func (h apiHandler) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.ids(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateItemReq
+	err := svc.DecodeJSON(w, r, &req)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	item, err := h.service.GetItem(r.Context(), userID, id)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	if req.Title != nil || req.Description != nil || req.DueAt != nil || req.IsDone != nil {
+		title, description, dueAt, done := item.Title, item.Description, item.DueAt.In(item.dueLocation()), item.IsDone
+		if req.Title != nil {
+			title = *req.Title
+		}
+		if req.Description != nil {
+			description = *req.Description
+		}
+		if req.DueAt != nil && !req.DueAt.IsZero() {
+			dueAt, err = dueAtIn(*req.DueAt, req.DueTimeZone)
+			if err != nil {
+				svc.WriteJSONError(w, err)
+				return
+			}
+		} else if req.DueAt != nil {
+			dueAt = time.Time{}
+		}
+		if req.IsDone != nil {
+			done = isDone(*req.IsDone)
+		}
+
+		item, err = h.service.SetItem(r.Context(), userID, id, title, description, dueAt, done)
+		if err != nil {
+			svc.WriteJSONError(w, err)
+			return
+		}
+	}
+
+	svc.WriteJSON(w, http.StatusOK, item.dto())
+}
+
+func (h apiHandler) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.ids(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.service.DeleteItem(r.Context(), userID, id)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,35 +1,104 @@
 package todo
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
 
 type list struct {
-	ID        uuid.UUID `redis:"id"`
-	CreatedAt time.Time `redis:"createdAt"`
-	Items     []*item   `redis:"createdAt"`
+	ID uuid.UUID `redis:"id"`
+	// Reference to user.ID
+	OwnerID    uuid.UUID `redis:"ownerId"`
+	CreatedAt  time.Time `redis:"createdAt"`
+	Title      string    `redis:"title"`
+	IsArchived bool      `redis:"isArchived"`
+	Items      []*item   `redis:"-"`
 }
 
-func newList() *list {
+func newList(ownerID uuid.UUID, title string) *list {
 	return &list{
 		ID:        uuid.New(),
+		OwnerID:   ownerID,
 		CreatedAt: time.Now(),
+		Title:     title,
+	}
+}
+
+const defaultListTitle = "Untitled list"
+
+func (l list) overdueItems(now time.Time) []*item {
+	overdue := make([]*item, 0)
+	for _, i := range l.Items {
+		if i.isOverdue(now) {
+			overdue = append(overdue, i)
+		}
+	}
+	return overdue
+}
+
+func newListTitle(t string) (string, error) {
+	t = strings.TrimSpace(t)
+
+	if len(t) == 0 {
+		return "", errors.New("List title cannot be empty")
+	}
+
+	if utf8.RuneCountInString(t) > 64 {
+		return "", errors.New("List title must be at most 64 characters")
+	}
+
+	return t, nil
+}
+
+// A list filled with a few example items, offered to new users so they can
+// see how things work.
+func newExampleList(ownerID uuid.UUID) *list {
+	l := newList(ownerID, "Example list")
+
+	item1 := newItem(l.ID, "Write notes on Chemistry 1", "")
+	item2 := newItem(l.ID, "Listen to CS lecture 240", "Take notes on Data structures & Algorithms")
+	item2.IsDone = true
+	item3 := newItem(l.ID, "Study HTMX", "HTMX is the best!")
+	item4 := newItem(l.ID, "Finish Chapter 12 of the Rust book", "")
+
+	l.Items = []*item{
+		item1,
+		item2,
+		item3,
+		item4,
 	}
+
+	return l
 }
 
 type item struct {
-	ID          uuid.UUID `redis:"id"`
-	CreatedAt   time.Time `redis:"createdAt"`
-	Title       string    `redis:"title"`
-	Description string    `redis:"description"`
-	IsDone      isDone    `redis:"isDone"`
+	ID uuid.UUID `redis:"id"`
+	// Reference to list.ID
+	ListID uuid.UUID `redis:"listId"`
+	// Reference to user.ID, always the owner of the list
+	OwnerID   uuid.UUID `redis:"ownerId"`
+	CreatedAt time.Time `redis:"createdAt"`
+	// Items in a list are sorted by ascending position
+	Position    float64 `redis:"position"`
+	Title       string  `redis:"title"`
+	Description string  `redis:"description"`
+	IsDone      isDone  `redis:"isDone"`
+	// Zero if the item has no due date
+	DueAt time.Time `redis:"dueAt"`
+	// IANA name of the time zone the due date was set in
+	DueTimeZone string `redis:"dueTimeZone"`
 }
 
-func newItem(title string, description string) *item {
+func newItem(listID uuid.UUID, title string, description string) *item {
 	return &item{
 		ID:          uuid.New(),
+		ListID:      listID,
 		CreatedAt:   time.Now(),
 		Title:       title,
 		Description: description,
@@ -37,4 +106,311 @@ func newItem(title string, description string) *item {
 	}
 }
 
+func newItemTitle(t string) (string, error) {
+	if len(t) == 0 {
+		return "", errors.New("Cannot have task names with a length of zero")
+	}
+	return t, nil
+}
+
 type isDone bool
+
+// Set the due date of an item, or clear it with a zero time.
+func (i *item) setDue(dueAt time.Time) {
+	if dueAt.IsZero() {
+		i.DueAt = time.Time{}
+		i.DueTimeZone = ""
+		return
+	}
+
+	i.DueAt = dueAt
+	i.DueTimeZone = dueAt.Location().String()
+}
+
+func (i item) hasDueDate() bool {
+	return !i.DueAt.IsZero()
+}
+
+// The time zone the due date was set in, falling back to UTC.
+func (i item) dueLocation() *time.Location {
+	loc, err := time.LoadLocation(i.DueTimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (i item) isOverdue(now time.Time) bool {
+	return i.hasDueDate() && !bool(i.IsDone) && i.DueAt.Before(now)
+}
+
+// A short description of when the item is due relative to now, such as
+// "due tomorrow" or "overdue 2d". Empty if the item has no due date.
+func (i item) dueLabel(now time.Time) string {
+	if !i.hasDueDate() {
+		return ""
+	}
+
+	if i.isOverdue(now) {
+		late := now.Sub(i.DueAt)
+		switch {
+		case late >= 24*time.Hour:
+			return fmt.Sprintf("overdue %vd", int(late/(24*time.Hour)))
+		case late >= time.Hour:
+			return fmt.Sprintf("overdue %vh", int(late/time.Hour))
+		default:
+			return fmt.Sprintf("overdue %vm", max(int(late/time.Minute), 1))
+		}
+	}
+
+	// Count calendar days in the time zone the due date was set in
+	loc := i.dueLocation()
+	due := i.DueAt.In(loc)
+	days := daysBetween(now.In(loc), due)
+
+	switch {
+	case days < 0:
+		return fmt.Sprintf("was due %v", due.Format("Jan 2"))
+	case days == 0:
+		return fmt.Sprintf("due today at %v", due.Format("3:04 PM"))
+	case days == 1:
+		return "due tomorrow"
+	case days < 7:
+		return fmt.Sprintf("due in %vd", days)
+	default:
+		return fmt.Sprintf("due %v", due.Format("Jan 2"))
+	}
+}
+
+// The number of calendar days from one date to another, ignoring the time of
+// day. Both times should be in the same location.
+func daysBetween(from time.Time, to time.Time) int {
+	y1, m1, d1 := from.Date()
+	y2, m2, d2 := to.Date()
+
+	start := time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC)
+
+	return int(end.Sub(start).Hours() / 24)
+}
+
+// The distance between the positions of neighbouring items when they are
+// appended or renumbered, leaving room to move items in between.
+const positionGap = 1024
+
+// The position for an item appended to the end of the list.
+func (l list) nextPosition() float64 {
+	if len(l.Items) == 0 {
+		return positionGap
+	}
+	return l.Items[len(l.Items)-1].Position + positionGap
+}
+
+// Assign new positions to the items of a list so that they follow the given
+// order of item IDs, returning only the items whose position changed.
+//
+// Items that are already in the right order relative to each other keep their
+// positions, and the moved items get positions in between their new
+// neighbours, so moving a single item only changes that item.
+func reorderItems(items []*item, order []uuid.UUID) ([]*item, error) {
+	if len(order) != len(items) {
+		return nil, errors.New("The new order must contain every item in the list exactly once")
+	}
+
+	byID := make(map[uuid.UUID]*item, len(items))
+	for _, i := range items {
+		byID[i.ID] = i
+	}
+
+	ordered := make([]*item, 0, len(order))
+	for _, id := range order {
+		i, ok := byID[id]
+		if !ok {
+			return nil, errors.New("The new order must contain every item in the list exactly once")
+		}
+		delete(byID, id)
+		ordered = append(ordered, i)
+	}
+
+	keep := longestIncreasingPositions(ordered)
+	positions := make([]float64, len(ordered))
+	for i, it := range ordered {
+		positions[i] = it.Position
+	}
+
+	for start := 0; start < len(ordered); {
+		if keep[start] {
+			start++
+			continue
+		}
+
+		// Spread the run of moved items [start, end) evenly between the
+		// kept neighbours on either side
+		end := start
+		for end < len(ordered) && !keep[end] {
+			end++
+		}
+
+		switch {
+		case start == 0 && end == len(ordered):
+			for i := start; i < end; i++ {
+				positions[i] = float64(i+1) * positionGap
+			}
+		case start == 0:
+			for i := end - 1; i >= start; i-- {
+				positions[i] = positions[i+1] - positionGap
+			}
+		case end == len(ordered):
+			for i := start; i < end; i++ {
+				positions[i] = positions[i-1] + positionGap
+			}
+		default:
+			lower, upper := positions[start-1], positions[end]
+			step := (upper - lower) / float64(end-start+1)
+			for i := start; i < end; i++ {
+				positions[i] = lower + step*float64(i-start+1)
+			}
+		}
+
+		start = end
+	}
+
+	// Renumber everything once the gaps between neighbours are too small to
+	// be represented
+	for i := 1; i < len(positions); i++ {
+		if positions[i] <= positions[i-1] {
+			for j := range positions {
+				positions[j] = float64(j+1) * positionGap
+			}
+			break
+		}
+	}
+
+	changed := make([]*item, 0)
+	for i, it := range ordered {
+		if it.Position != positions[i] {
+			it.Position = positions[i]
+			changed = append(changed, it)
+		}
+	}
+
+	return changed, nil
+}
+
+// Mark the longest run of items, not necessarily adjacent, whose positions
+// are already strictly increasing.
+func longestIncreasingPositions(items []*item) []bool {
+	// tails[k] is the index of the smallest tail of an increasing run of
+	// length k+1, and prev links each index to the one before it in its run
+	tails := make([]int, 0, len(items))
+	prev := make([]int, len(items))
+
+	for i, it := range items {
+		k := sort.Search(len(tails), func(k int) bool {
+			return items[tails[k]].Position >= it.Position
+		})
+
+		prev[i] = -1
+		if k > 0 {
+			prev[i] = tails[k-1]
+		}
+
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+
+	keep := make([]bool, len(items))
+	if len(tails) == 0 {
+		return keep
+	}
+	for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+		keep[i] = true
+	}
+
+	return keep
+}
+
+// What a user may do with a list. Each role can do everything the roles
+// below it can.
+type Role string
+
+const (
+	// Can also share, archive and delete the list
+	RoleOwner Role = "owner"
+	// Can also rename the list and change its items
+	RoleEditor Role = "editor"
+	// Can see the list and its items
+	RoleViewer Role = "viewer"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// Whether the role can do everything the other role can
+func (r Role) includes(other Role) bool {
+	return roleRanks[r] >= roleRanks[other]
+}
+
+// The role of someone a list is shared with, which is never the owner
+func newCollaboratorRole(r string) (Role, error) {
+	switch Role(r) {
+	case RoleEditor, RoleViewer:
+		return Role(r), nil
+	default:
+		return "", errors.New("Collaborators must be either editors or viewers")
+	}
+}
+
+// A user a list is shared with
+type collaborator struct {
+	// Reference to list.ID
+	ListID uuid.UUID `redis:"listId"`
+	// Reference to user.ID
+	UserID  uuid.UUID `redis:"userId"`
+	Role    Role      `redis:"role"`
+	AddedAt time.Time `redis:"addedAt"`
+	// Looked up when the collaborators are listed, since it can change
+	Username string `redis:"-"`
+}
+
+func newCollaborator(listID uuid.UUID, userID uuid.UUID, role Role) *collaborator {
+	return &collaborator{
+		ListID:  listID,
+		UserID:  userID,
+		Role:    role,
+		AddedAt: time.Now(),
+	}
+}
+
+// Everyone with access to a list, as seen by one of them
+type sharing struct {
+	ListID uuid.UUID
+	// The user looking at the list
+	UserID uuid.UUID
+	// The owner first
+	Collaborators []*collaborator
+}
+
+// The role of the user looking at the list
+func (s sharing) role() Role {
+	for _, c := range s.Collaborators {
+		if c.UserID == s.UserID {
+			return c.Role
+		}
+	}
+	return ""
+}
+
+// Owners can remove anyone else, and collaborators can remove themselves
+func (s sharing) canRemove(c *collaborator) bool {
+	if c.Role == RoleOwner {
+		return false
+	}
+	return s.role() == RoleOwner || c.UserID == s.UserID
+}
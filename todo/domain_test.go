@@ -0,0 +1,135 @@
+package todo
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newPositionedItems(positions ...float64) []*item {
+	items := make([]*item, 0, len(positions))
+	for _, p := range positions {
+		i := newItem(uuid.Nil, "", "")
+		i.Position = p
+		items = append(items, i)
+	}
+	return items
+}
+
+func idsOf(items ...*item) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(items))
+	for _, i := range items {
+		ids = append(ids, i.ID)
+	}
+	return ids
+}
+
+func assertIncreasing(t *testing.T, items ...*item) {
+	t.Helper()
+
+	for i := 1; i < len(items); i++ {
+		if items[i].Position <= items[i-1].Position {
+			t.Fatalf("positions are not increasing: item %v at %v, item %v at %v",
+				i-1, items[i-1].Position, i, items[i].Position)
+		}
+	}
+}
+
+func TestReorderItemsMovesOnlyTheMovedItem(t *testing.T) {
+	items := newPositionedItems(1024, 2048, 3072, 4096)
+	a, b, c, d := items[0], items[1], items[2], items[3]
+
+	changed, err := reorderItems(items, idsOf(a, d, b, c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changed) != 1 || changed[0] != d {
+		t.Fatalf("got %v changed items, want only the moved one", len(changed))
+	}
+	assertIncreasing(t, a, d, b, c)
+}
+
+func TestReorderItemsToTheEdges(t *testing.T) {
+	items := newPositionedItems(1024, 2048, 3072)
+	a, b, c := items[0], items[1], items[2]
+
+	if _, err := reorderItems(items, idsOf(c, a, b)); err != nil {
+		t.Fatal(err)
+	}
+	assertIncreasing(t, c, a, b)
+
+	if _, err := reorderItems(items, idsOf(a, b, c)); err != nil {
+		t.Fatal(err)
+	}
+	assertIncreasing(t, a, b, c)
+}
+
+func TestReorderItemsRenumbersWhenOutOfRoom(t *testing.T) {
+	// No float64 fits between a and b
+	items := newPositionedItems(1, math.Nextafter(1, 2), 1024)
+	a, b, c := items[0], items[1], items[2]
+
+	changed, err := reorderItems(items, idsOf(a, c, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changed) != 3 {
+		t.Fatalf("got %v changed items, want all of them", len(changed))
+	}
+	assertIncreasing(t, a, c, b)
+}
+
+func TestReorderItemsRejectsOtherItems(t *testing.T) {
+	items := newPositionedItems(1024, 2048)
+
+	if _, err := reorderItems(items, idsOf(items[0])); err == nil {
+		t.Error("expected an error for a missing item")
+	}
+	if _, err := reorderItems(items, idsOf(items[0], items[0])); err == nil {
+		t.Error("expected an error for a duplicated item")
+	}
+	if _, err := reorderItems(items, []uuid.UUID{items[0].ID, uuid.New()}); err == nil {
+		t.Error("expected an error for an item from another list")
+	}
+}
+
+func TestDueLabel(t *testing.T) {
+	manila, err := time.LoadLocation("Asia/Manila")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 PM in Manila, so the next day starts in two hours
+	now := time.Date(2023, time.November, 20, 22, 0, 0, 0, manila)
+
+	tests := []struct {
+		dueAt  time.Time
+		isDone bool
+		want   string
+	}{
+		{time.Time{}, false, ""},
+		{now.Add(time.Hour), false, "due today at 11:00 PM"},
+		{now.Add(3 * time.Hour), false, "due tomorrow"},
+		{now.Add(3 * 24 * time.Hour), false, "due in 3d"},
+		{now.Add(30 * 24 * time.Hour), false, "due Dec 20"},
+		{now.Add(-10 * time.Minute), false, "overdue 10m"},
+		{now.Add(-5 * time.Hour), false, "overdue 5h"},
+		{now.Add(-50 * time.Hour), false, "overdue 2d"},
+		{now.Add(-50 * time.Hour), true, "was due Nov 18"},
+	}
+
+	for _, tt := range tests {
+		i := newItem(uuid.Nil, "", "")
+		i.setDue(tt.dueAt)
+		i.IsDone = isDone(tt.isDone)
+
+		// The label should not depend on the time zone of the server
+		if got := i.dueLabel(now.UTC()); got != tt.want {
+			t.Errorf("due %v (done: %v): got %q, want %q", tt.dueAt, tt.isDone, got, tt.want)
+		}
+	}
+}
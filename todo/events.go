@@ -0,0 +1,163 @@
+// events.go provides events of changes to todo items, so every tab showing
+// a list can be kept up to date
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+type EventType string
+
+const (
+	EventItemCreated EventType = "created"
+	EventItemUpdated EventType = "updated"
+	EventItemToggled EventType = "toggled"
+	EventItemDeleted EventType = "deleted"
+)
+
+// Only IDs are sent, so subscribers get the item as it is when they render
+// it, and never see items they may no longer access
+type ItemEvent struct {
+	Type   EventType `json:"type"`
+	ListID uuid.UUID `json:"listId"`
+	ItemID uuid.UUID `json:"itemId"`
+}
+
+// Events are delivered at most once. Subscribers that fall behind miss
+// events rather than hold up publishers.
+type EventBus interface {
+	Publish(ctx context.Context, e ItemEvent) error
+	// Receive the events of a list until ctx is done, after which the
+	// channel is closed
+	Subscribe(ctx context.Context, listID uuid.UUID) (<-chan ItemEvent, error)
+}
+
+// How many events a subscriber can fall behind by
+const eventBufferSize = 16
+
+// Only reaches subscribers of the same instance
+type memoryEventBus struct {
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[chan ItemEvent]struct{}
+}
+
+func NewMemoryEventBus() EventBus {
+	return &memoryEventBus{
+		subscribers: make(map[uuid.UUID]map[chan ItemEvent]struct{}),
+	}
+}
+
+func (b *memoryEventBus) Publish(ctx context.Context, e ItemEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[e.ListID] {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (b *memoryEventBus) Subscribe(ctx context.Context, listID uuid.UUID) (<-chan ItemEvent, error) {
+	ch := make(chan ItemEvent, eventBufferSize)
+
+	b.mu.Lock()
+	if b.subscribers[listID] == nil {
+		b.subscribers[listID] = make(map[chan ItemEvent]struct{})
+	}
+	b.subscribers[listID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subscribers[listID], ch)
+		if len(b.subscribers[listID]) == 0 {
+			delete(b.subscribers, listID)
+		}
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// Reaches subscribers of every instance connected to the same Redis
+type redisEventBus struct {
+	redis *redis.Client
+}
+
+// Pub/sub channel of the events of a list
+const redisFmtListEvents = "lists:%v:events"
+
+func NewRedisEventBus(redis *redis.Client) EventBus {
+	return &redisEventBus{
+		redis: redis,
+	}
+}
+
+func (b *redisEventBus) Publish(ctx context.Context, e ItemEvent) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return b.redis.Publish(ctx, fmt.Sprintf(redisFmtListEvents, e.ListID.String()), payload).Err()
+}
+
+func (b *redisEventBus) Subscribe(ctx context.Context, listID uuid.UUID) (<-chan ItemEvent, error) {
+	pubsub := b.redis.Subscribe(ctx, fmt.Sprintf(redisFmtListEvents, listID.String()))
+
+	// Wait until subscribed, so no event published after this returns is
+	// missed
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	ch := make(chan ItemEvent, eventBufferSize)
+
+	go func() {
+		defer close(ch)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				var e ItemEvent
+				err := json.Unmarshal([]byte(msg.Payload), &e)
+				if err != nil {
+					log.Printf("decoding event of list %v: %v", listID, err)
+					continue
+				}
+
+				select {
+				case ch <- e:
+				default:
+				}
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,211 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/google/uuid"
+)
+
+// Get the next event, failing if none comes soon
+func nextEvent(t *testing.T, events <-chan ItemEvent) ItemEvent {
+	t.Helper()
+
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("got no event")
+		return ItemEvent{}
+	}
+}
+
+func TestMemoryEventBus(t *testing.T) {
+	bus := NewMemoryEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listID := uuid.New()
+	events, err := bus.Subscribe(ctx, listID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only events of the subscribed list arrive
+	other := ItemEvent{Type: EventItemCreated, ListID: uuid.New(), ItemID: uuid.New()}
+	want := ItemEvent{Type: EventItemToggled, ListID: listID, ItemID: uuid.New()}
+	for _, e := range []ItemEvent{other, want} {
+		if err := bus.Publish(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := nextEvent(t, events); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// A subscriber that falls behind doesn't hold up publishing
+	for i := 0; i < eventBufferSize*2; i++ {
+		if err := bus.Publish(ctx, want); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cancel()
+	for range events {
+		// The channel is closed once the subscription ends
+	}
+}
+
+func TestServicePublishesItemEvents(t *testing.T) {
+	sv := NewService(newTestSQLiteRepository(t), NewMemoryEventBus(), testDirectory{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	owner := uuid.New()
+	stranger := uuid.New()
+
+	l := newList(owner, "Groceries")
+	if _, err := sv.CreateList(ctx, &owner, l); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sv.SubscribeList(ctx, &stranger, &l.ID); err == nil {
+		t.Error("subscribed to someone else's list")
+	}
+	events, err := sv.SubscribeList(ctx, &owner, &l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := newItem(l.ID, "Milk", "")
+	if _, err := sv.CreateItem(ctx, &owner, i); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.ToggleItemComplete(ctx, &owner, &i.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.UpdateItem(ctx, &owner, &i.ID, "Oat milk", "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sv.DeleteItem(ctx, &owner, &i.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, eventType := range []EventType{EventItemCreated, EventItemToggled, EventItemUpdated, EventItemDeleted} {
+		want := ItemEvent{Type: eventType, ListID: l.ID, ItemID: i.ID}
+		if got := nextEvent(t, events); got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	var b strings.Builder
+	if err := writeEvent(&b, "item-created", "<li>\n\tMilk\n</li>"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "event: item-created\ndata: <li>\ndata: \tMilk\ndata: </li>\n\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestWriteItemEventChecksAccess(t *testing.T) {
+	owner := uuid.New()
+	friend := uuid.New()
+	sv := NewService(newTestSQLiteRepository(t), NewMemoryEventBus(), testDirectory{
+		"owner":  owner,
+		"friend": friend,
+	})
+	h := handler{service: sv}
+	ctx := context.Background()
+
+	l := newList(owner, "Groceries")
+	if _, err := sv.CreateList(ctx, &owner, l); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.ShareList(ctx, &owner, &l.ID, "friend", "viewer"); err != nil {
+		t.Fatal(err)
+	}
+	i := newItem(l.ID, "Milk", "")
+	if _, err := sv.CreateItem(ctx, &owner, i); err != nil {
+		t.Fatal(err)
+	}
+
+	created := ItemEvent{Type: EventItemCreated, ListID: l.ID, ItemID: i.ID}
+	deleted := ItemEvent{Type: EventItemDeleted, ListID: l.ID, ItemID: i.ID}
+
+	var b strings.Builder
+	if err := h.writeItemEvent(ctx, &b, &friend, created); err != nil {
+		t.Fatal(err)
+	}
+	// With the ID of the entry the tab that added it already shows
+	if !strings.Contains(b.String(), "Milk") || !strings.Contains(b.String(), i.entryID()) {
+		t.Errorf("got %q, want the item's entry", b.String())
+	}
+
+	// Once unshared, even deletions are no longer sent
+	if err := sv.UnshareList(ctx, &owner, &l.ID, &friend); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []ItemEvent{created, deleted} {
+		b.Reset()
+		if err := h.writeItemEvent(ctx, &b, &friend, e); !errors.Is(err, svc.ErrForbidden) {
+			t.Errorf("%v after unsharing: got %v, want ErrForbidden", e.Type, err)
+		}
+		if b.Len() != 0 {
+			t.Errorf("%v after unsharing: wrote %q", e.Type, b.String())
+		}
+	}
+}
+
+func TestSetItemWritesOnce(t *testing.T) {
+	sv := NewService(newTestSQLiteRepository(t), NewMemoryEventBus(), testDirectory{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	owner := uuid.New()
+	l := newList(owner, "Groceries")
+	if _, err := sv.CreateList(ctx, &owner, l); err != nil {
+		t.Fatal(err)
+	}
+	i := newItem(l.ID, "Milk", "")
+	if _, err := sv.CreateItem(ctx, &owner, i); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := sv.SubscribeList(ctx, &owner, &l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dueAt := time.Date(2030, time.January, 2, 15, 0, 0, 0, time.UTC)
+	if _, err := sv.SetItem(ctx, &owner, &i.ID, "Oat milk", "Unsweetened", dueAt, true); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sv.GetItem(ctx, &owner, &i.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Oat milk" || got.Description != "Unsweetened" || !got.DueAt.Equal(dueAt) || !bool(got.IsDone) {
+		t.Errorf("got %+v, want all fields set", got)
+	}
+
+	// One change, so one event
+	want := ItemEvent{Type: EventItemUpdated, ListID: l.ID, ItemID: i.ID}
+	if e := nextEvent(t, events); e != want {
+		t.Errorf("got %+v, want %+v", e, want)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("got another event %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,10 +1,17 @@
 package todo
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/angelofallars/htmx-chi-todo/auth"
 	svc "github.com/angelofallars/htmx-chi-todo/service"
 	"github.com/angelofallars/htmx-chi-todo/site"
 	"github.com/angelofallars/htmx-go"
@@ -16,6 +23,18 @@ type (
 	Handler interface {
 		svc.HandlerMounter
 		Page(w http.ResponseWriter, r *http.Request)
+		ListsPage(w http.ResponseWriter, r *http.Request)
+		ListPage(w http.ResponseWriter, r *http.Request)
+		CreateList(w http.ResponseWriter, r *http.Request)
+		CreateExampleList(w http.ResponseWriter, r *http.Request)
+		RenameList(w http.ResponseWriter, r *http.Request)
+		ArchiveList(w http.ResponseWriter, r *http.Request)
+		UnarchiveList(w http.ResponseWriter, r *http.Request)
+		DeleteList(w http.ResponseWriter, r *http.Request)
+		ReorderItems(w http.ResponseWriter, r *http.Request)
+		ListEvents(w http.ResponseWriter, r *http.Request)
+		ShareList(w http.ResponseWriter, r *http.Request)
+		UnshareList(w http.ResponseWriter, r *http.Request)
 		GetList(w http.ResponseWriter, r *http.Request)
 		GetItem(w http.ResponseWriter, r *http.Request)
 		CreateItem(w http.ResponseWriter, r *http.Request)
@@ -29,9 +48,21 @@ type (
 
 func (h handler) Mount(r chi.Router) {
 	r.Get("/", h.Page)
-	r.Get("/items", h.GetList)
+	r.Get("/lists", h.ListsPage)
+	r.Post("/lists", h.CreateList)
+	r.Post("/lists/example", h.CreateExampleList)
+	r.Get("/lists/{id}", h.ListPage)
+	r.Put("/lists/{id}", h.RenameList)
+	r.Put("/lists/{id}/archive", h.ArchiveList)
+	r.Put("/lists/{id}/unarchive", h.UnarchiveList)
+	r.Delete("/lists/{id}", h.DeleteList)
+	r.Get("/lists/{id}/items", h.GetList)
+	r.Post("/lists/{id}/items", h.CreateItem)
+	r.Put("/lists/{id}/order", h.ReorderItems)
+	r.Get("/lists/{id}/events", h.ListEvents)
+	r.Post("/lists/{id}/collaborators", h.ShareList)
+	r.Post("/lists/{id}/collaborators/{userId}/remove", h.UnshareList)
 	r.Get("/items/{id}", h.GetItem)
-	r.Post("/items", h.CreateItem)
 	r.Put("/items/{id}/toggle", h.ToggleItemComplete)
 	r.Put("/items/{id}", h.UpdateItem)
 	r.Delete("/items/{id}", h.DeleteItem)
@@ -43,110 +74,504 @@ func NewHandler(service Service) Handler {
 	}
 }
 
+// Attempt to extract the ID of the logged-in user from the request,
+// otherwise return an error
+func userIDFromRequest(r *http.Request) (*uuid.UUID, error) {
+	claims, err := auth.JwtClaimsFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &id, nil
+}
+
+// The format of <input type="datetime-local"> values
+const datetimeLocalFormat = "2006-01-02T15:04"
+
+// Parse the optional due date of an item from a submitted form. The date is
+// interpreted in the browser's time zone, sent along in the "timezone" field.
+func dueAtFromForm(r *http.Request) (time.Time, error) {
+	rawDueAt := r.Form.Get("task-due")
+	if len(rawDueAt) == 0 {
+		return time.Time{}, nil
+	}
+
+	loc, err := time.LoadLocation(r.Form.Get("timezone"))
+	if err != nil {
+		loc = time.UTC
+	}
+
+	dueAt, err := time.ParseInLocation(datetimeLocalFormat, rawDueAt, loc)
+	if err != nil {
+		return time.Time{}, errors.New("Invalid due date")
+	}
+
+	return dueAt, nil
+}
+
+// Show the user's most recently created list, or help them create their
+// first one.
 func (h handler) Page(w http.ResponseWriter, r *http.Request) {
-	list := newList()
-
-	item1 := newItem("Write notes on Chemistry 1", "")
-	item2 := newItem("Listen to CS lecture 240", "Take notes on Data structures & Algorithms")
-	item2.IsDone = true
-	item3 := newItem("Study HTMX", "HTMX is the best!")
-	item4 := newItem("Finish Chapter 12 of the Rust book", "")
-
-	list.Items = []*item{
-		item1,
-		item2,
-		item3,
-		item4,
-	}
-
-	_, err := h.service.CreateList(r.Context(), list)
-	_, err = h.service.CreateItem(r.Context(), item1)
-	_, err = h.service.CreateItem(r.Context(), item2)
-	_, err = h.service.CreateItem(r.Context(), item3)
-	_, err = h.service.CreateItem(r.Context(), item4)
-	if err != nil {
-		site.RenderError(w,
-			http.StatusInternalServerError,
-			err,
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	lists, err := h.service.GetLists(r.Context(), userID, false)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	if len(lists) == 0 {
+		site.RenderRootOrPartial(w, r,
+			"TODO",
+			onboardingPage(),
 		)
 		return
 	}
 
-	w.Header().Add(htmx.HeaderReplaceUrl, fmt.Sprintf("/%v", list.ID.String()))
+	h.renderListPage(w, r, userID, lists[0], false)
+}
+
+func (h handler) CreateList(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	// Sent by the hx-prompt on the "New list" button
+	title, ok := htmx.GetPrompt(r)
+	if !ok || len(title) == 0 {
+		title = defaultListTitle
+	}
+
+	list := newList(*userID, title)
+
+	_, err = h.service.CreateList(r.Context(), userID, list)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	htmx.NewResponse().
+		Redirect(list.url()).
+		Write(w)
+}
+
+func (h handler) CreateExampleList(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	list, err := h.service.CreateExampleList(r.Context(), userID)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	htmx.NewResponse().
+		Redirect(list.url()).
+		Write(w)
+}
+
+func (h handler) ListsPage(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	archived := r.URL.Query().Get("archived") == "true"
+
+	lists, err := h.service.GetLists(r.Context(), userID, archived)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	shared, err := h.service.GetSharedLists(r.Context(), userID, archived)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	// Remind the user of everything overdue or due within the next day
+	dueSoon, err := h.service.GetItemsDue(r.Context(), userID, time.Unix(0, 0), time.Now().Add(24*time.Hour))
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
 
 	site.RenderRootOrPartial(w, r,
-		"TODO",
-		page(list),
+		"My Todo Lists",
+		listsPage(lists, shared, archived, dueSoon),
 	)
 }
 
+func (h handler) ListPage(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	list, err := h.service.GetList(r.Context(), userID, &id)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	overdueOnly := r.URL.Query().Get("filter") == "overdue"
+
+	h.renderListPage(w, r, userID, list, overdueOnly)
+}
+
+// Render the page of a list along with who it is shared with
+func (h handler) renderListPage(w http.ResponseWriter, r *http.Request, userID *uuid.UUID, l *list, overdueOnly bool) {
+	s, err := h.getSharing(r.Context(), userID, &l.ID)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	site.RenderRootOrPartial(w, r,
+		l.Title,
+		page(l, overdueOnly, s),
+	)
+}
+
+func (h handler) getSharing(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID) (*sharing, error) {
+	collaborators, err := h.service.GetCollaborators(ctx, userID, listID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sharing{
+		ListID:        *listID,
+		UserID:        *userID,
+		Collaborators: collaborators,
+	}, nil
+}
+
+// Share a list with the user of the submitted username or email
+func (h handler) ShareList(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.ParseForm()
+
+	_, err = h.service.ShareList(r.Context(), userID, &id, r.Form.Get("collaborator"), r.Form.Get("role"))
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	s, err := h.getSharing(r.Context(), userID, &id)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	s.Component().Render(r.Context(), w)
+}
+
+// Remove a collaborator from a list, or leave it if they are the user
+func (h handler) UnshareList(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	collaboratorID, err := uuid.Parse(chi.URLParam(r, "userId"))
+	if err != nil {
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.service.UnshareList(r.Context(), userID, &id, &collaboratorID)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	// The list is no longer theirs to see
+	if collaboratorID == *userID {
+		htmx.NewResponse().
+			Redirect("/lists").
+			Write(w)
+		return
+	}
+
+	s, err := h.getSharing(r.Context(), userID, &id)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	s.Component().Render(r.Context(), w)
+}
+
+func (h handler) RenameList(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.ParseForm()
+	title := r.Form.Get("list-title")
+
+	list, err := h.service.RenameList(r.Context(), userID, &id, title)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	role, err := h.service.GetRole(r.Context(), userID, &id)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	list.header(role).Render(r.Context(), w)
+}
+
+func (h handler) ArchiveList(w http.ResponseWriter, r *http.Request) {
+	h.setListArchived(w, r, true)
+}
+
+func (h handler) UnarchiveList(w http.ResponseWriter, r *http.Request) {
+	h.setListArchived(w, r, false)
+}
+
+func (h handler) setListArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	list, err := h.service.SetListArchived(r.Context(), userID, &id, archived)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	// Only owners can archive lists
+	list.header(RoleOwner).Render(r.Context(), w)
+}
+
+func (h handler) DeleteList(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.service.DeleteList(r.Context(), userID, &id)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	htmx.NewResponse().
+		Redirect("/lists").
+		Write(w)
+}
+
 func (h handler) CreateItem(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	listID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	r.ParseForm()
 	name := r.Form.Get("task-name")
 	description := r.Form.Get("task-description")
 
-	if len(name) == 0 {
-		site.RenderError(w,
-			http.StatusBadRequest,
-			errors.New("Cannot have task names with a length of zero"),
-		)
+	dueAt, err := dueAtFromForm(r)
+	if err != nil {
+		site.RenderError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	item := newItem(name, description)
+	item := newItem(listID, name, description)
+	item.setDue(dueAt)
 
-	_, err := h.service.CreateItem(r.Context(), item)
+	_, err = h.service.CreateItem(r.Context(), userID, item)
 
 	if err != nil {
-		site.RenderError(w,
-			http.StatusInternalServerError,
-			err,
-		)
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	role, err := h.service.GetRole(r.Context(), userID, &listID)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	item.listEntry(role).Render(r.Context(), w)
+}
+
+// Save the order of a list's items after one was dragged to a new place.
+// The request carries the item IDs in their new order.
+func (h handler) ReorderItems(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	listID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.ParseForm()
+	order := make([]uuid.UUID, 0, len(r.Form["item"]))
+	for _, rawID := range r.Form["item"] {
+		id, err := uuid.Parse(rawID)
+		if err != nil {
+			site.RenderError(w, http.StatusBadRequest, err)
+			return
+		}
+		order = append(order, id)
+	}
+
+	err = h.service.ReorderItems(r.Context(), userID, &listID, order)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
 		return
 	}
 
-	item.Component().Render(r.Context(), w)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h handler) GetList(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
 	id, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
 		site.RenderError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	list, err := h.service.GetList(r.Context(), &id)
+	list, err := h.service.GetList(r.Context(), userID, &id)
 	if err != nil {
-		site.RenderError(w,
-			http.StatusInternalServerError,
-			err,
-		)
+		site.RenderError(w, svc.StatusCode(err), err)
 		return
 	}
 
-	list.Component().Render(r.Context(), w)
+	role, err := h.service.GetRole(r.Context(), userID, &id)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	list.Component(role).Render(r.Context(), w)
 }
 
 func (h handler) GetItem(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
 	id, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
 		site.RenderError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	item, err := h.service.GetItem(r.Context(), &id)
+	item, err := h.service.GetItem(r.Context(), userID, &id)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	role, err := h.service.GetRole(r.Context(), userID, &item.ListID)
 	if err != nil {
-		site.RenderError(w, http.StatusInternalServerError, err)
+		site.RenderError(w, svc.StatusCode(err), err)
 		return
 	}
 
-	item.Component().Render(r.Context(), w)
+	item.Component(role).Render(r.Context(), w)
 }
 
 func (h handler) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
 	id, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
-		site.RenderError(w, http.StatusInternalServerError, err)
+		site.RenderError(w, http.StatusBadRequest, err)
 		return
 	}
 
@@ -154,44 +579,182 @@ func (h handler) UpdateItem(w http.ResponseWriter, r *http.Request) {
 	name := r.Form.Get("task-name")
 	description := r.Form.Get("task-description")
 
-	item, err := h.service.UpdateItem(r.Context(), &id, name, description)
+	dueAt, err := dueAtFromForm(r)
 	if err != nil {
-		site.RenderError(w,
-			http.StatusInternalServerError,
-			err,
-		)
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := h.service.UpdateItem(r.Context(), userID, &id, name, description, dueAt)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	role, err := h.service.GetRole(r.Context(), userID, &item.ListID)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
 		return
 	}
 
-	item.Component().Render(r.Context(), w)
+	item.Component(role).Render(r.Context(), w)
 }
 
 func (h handler) ToggleItemComplete(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
 	id, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
 		site.RenderError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	completionStatus, err := h.service.ToggleItemComplete(r.Context(), &id)
+	completionStatus, err := h.service.ToggleItemComplete(r.Context(), userID, &id)
 	if err != nil {
-		site.RenderError(w, http.StatusInternalServerError, err)
+		site.RenderError(w, svc.StatusCode(err), err)
 		return
 	}
 
-	completionStatus.Component(id).Render(r.Context(), w)
+	// Only users who could toggle it get here
+	completionStatus.Component(id, true).Render(r.Context(), w)
 }
 
 func (h handler) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
 	id, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
-		site.RenderError(w, http.StatusInternalServerError, err)
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.service.DeleteItem(r.Context(), userID, &id)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+}
+
+// Comments sent this often keep proxies from closing idle event streams
+const eventStreamKeepAlive = 30 * time.Second
+
+// Stream changes to the items of a list as Server-Sent Events, each with the
+// HTML to swap in, for htmx's SSE extension to keep other tabs up to date.
+func (h handler) ListEvents(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		site.RenderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		site.RenderError(w, http.StatusInternalServerError, errors.New("Streaming is not supported"))
 		return
 	}
 
-	err = h.service.DeleteItem(r.Context(), &id)
+	ctx := r.Context()
+
+	events, err := h.service.SubscribeList(ctx, userID, &id)
 	if err != nil {
-		site.RenderError(w, http.StatusInternalServerError, err)
+		site.RenderError(w, svc.StatusCode(err), err)
 		return
 	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			err = h.writeItemEvent(ctx, w, userID, e)
+		}
+
+		if errors.Is(err, svc.ErrForbidden) {
+			// The list was unshared with the user
+			return
+		} else if err != nil {
+			log.Printf("streaming events of list %v: %v", id, err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// Write an event with the HTML of the item as it is now. Fails with
+// svc.ErrForbidden once the user can no longer see the list, since the
+// stream was only authorized when it started.
+func (h handler) writeItemEvent(ctx context.Context, w io.Writer, userID *uuid.UUID, e ItemEvent) error {
+	role, err := h.service.GetRole(ctx, userID, &e.ListID)
+	if err != nil {
+		return err
+	}
+
+	if e.Type == EventItemDeleted {
+		return writeEvent(w, itemDeletedEvent(e.ItemID), "")
+	}
+
+	item, err := h.service.GetItem(ctx, userID, &e.ItemID)
+	if errors.Is(err, svc.ErrNotExists) {
+		// Deleted since, which has an event of its own
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var html bytes.Buffer
+	if e.Type == EventItemCreated {
+		err = item.listEntry(role).Render(ctx, &html)
+		if err != nil {
+			return err
+		}
+		return writeEvent(w, itemCreatedEvent, html.String())
+	}
+
+	err = item.Component(role).Render(ctx, &html)
+	if err != nil {
+		return err
+	}
+	return writeEvent(w, item.changedEvent(), html.String())
+}
+
+// Write a Server-Sent Event, putting each line of data on a line of its own
+func writeEvent(w io.Writer, event string, data string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "event: %v\n", event)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %v\n", line)
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
 }
@@ -2,10 +2,12 @@ package todo
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
+	svc "github.com/angelofallars/htmx-chi-todo/service"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
@@ -14,12 +16,31 @@ type (
 	Repository interface {
 		CreateList(ctx context.Context, l *list) (*uuid.UUID, error)
 		GetList(ctx context.Context, uuid *uuid.UUID) (*list, error)
-		GetLists(ctx context.Context) ([]*list, error)
+		GetLists(ctx context.Context, ownerID *uuid.UUID) ([]*list, error)
+		UpdateList(ctx context.Context, l *list) error
+		// Delete a list along with all of its items and collaborators.
+		DeleteList(ctx context.Context, id *uuid.UUID) error
 
 		GetItem(ctx context.Context, uuid *uuid.UUID) (*item, error)
 		CreateItem(ctx context.Context, i *item) (*uuid.UUID, error)
 		UpdateItem(ctx context.Context, uuid *uuid.UUID, i *item) error
 		DeleteItem(ctx context.Context, uuid *uuid.UUID) error
+		// Save the positions of several items at once.
+		UpdateItemPositions(ctx context.Context, items []*item) error
+		// Get a user's items that are due within a time window, soonest first.
+		GetItemsDue(ctx context.Context, ownerID *uuid.UUID, from time.Time, to time.Time) ([]*item, error)
+
+		// Share a list with a user, or change their role if it already is.
+		SetCollaborator(ctx context.Context, c *collaborator) error
+		GetCollaborator(ctx context.Context, listID *uuid.UUID, userID *uuid.UUID) (*collaborator, error)
+		// Get the users a list is shared with, in the order it was shared
+		// with them.
+		GetCollaborators(ctx context.Context, listID *uuid.UUID) ([]*collaborator, error)
+		RemoveCollaborator(ctx context.Context, listID *uuid.UUID, userID *uuid.UUID) error
+		// Get the lists shared with a user, most recently shared first.
+		GetSharedLists(ctx context.Context, userID *uuid.UUID) ([]*list, error)
+		// Stop sharing every list with a user.
+		RemoveCollaborations(ctx context.Context, userID *uuid.UUID) error
 	}
 
 	redisRepository struct {
@@ -30,6 +51,21 @@ type (
 const (
 	redisFmtList = "lists:%v"
 	redisFmtItem = "items:%v"
+	// Sorted set of a list's item IDs, scored by position
+	redisFmtListItems = "lists:%v:items"
+	// Sorted set of a user's list IDs, scored by creation time
+	redisFmtUserLists = "users:%v:lists"
+	// Sorted set of a user's item IDs that have a due date, scored by the
+	// due date as a Unix timestamp
+	redisFmtUserDueItems = "users:%v:due"
+	// Hash of a user's role in a list shared with them
+	redisFmtListCollaborator = "lists:%v:collaborators:%v"
+	// Sorted set of the IDs of the users a list is shared with, scored by
+	// when it was shared
+	redisFmtListCollaborators = "lists:%v:collaborators"
+	// Sorted set of the IDs of the lists shared with a user, scored by when
+	// they were shared
+	redisFmtUserSharedLists = "users:%v:shared"
 )
 
 func NewRedisRepository(redis *redis.Client) Repository {
@@ -38,20 +74,26 @@ func NewRedisRepository(redis *redis.Client) Repository {
 	}
 }
 
-func (r redisRepository) CreateList(ctx context.Context, l *list) (*uuid.UUID, error) {
-	m := map[string]any{
-		"id":        l.ID.String(),
-		"createdAt": l.CreatedAt,
+func listToMap(l *list) map[string]any {
+	return map[string]any{
+		"id":         l.ID.String(),
+		"ownerId":    l.OwnerID.String(),
+		"createdAt":  l.CreatedAt,
+		"title":      l.Title,
+		"isArchived": l.IsArchived,
 	}
+}
 
-	bytes, err := json.Marshal(l.Items)
-	if err != nil {
-		return nil, err
-	}
+func (r redisRepository) CreateList(ctx context.Context, l *list) (*uuid.UUID, error) {
+	pipe := r.redis.TxPipeline()
 
-	m["items"] = bytes
+	pipe.HSet(ctx, fmt.Sprintf(redisFmtList, l.ID.String()), listToMap(l))
+	pipe.ZAdd(ctx, fmt.Sprintf(redisFmtUserLists, l.OwnerID.String()), redis.Z{
+		Score:  float64(l.CreatedAt.Unix()),
+		Member: l.ID.String(),
+	})
 
-	_, err = r.redis.HSet(ctx, fmt.Sprintf(redisFmtList, l.ID.String()), m).Result()
+	_, err := pipe.Exec(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -65,6 +107,10 @@ func (r redisRepository) GetList(ctx context.Context, uuid *uuid.UUID) (*list, e
 		return nil, cmd.Err()
 	}
 
+	if len(cmd.Val()) == 0 {
+		return nil, svc.ErrNotExists
+	}
+
 	l := new(list)
 
 	err := cmd.Scan(l)
@@ -72,38 +118,145 @@ func (r redisRepository) GetList(ctx context.Context, uuid *uuid.UUID) (*list, e
 		return nil, err
 	}
 
-	items := new([]*item)
-
-	err = json.Unmarshal([]byte(cmd.Val()["items"]), items)
+	items, err := r.getListItems(ctx, uuid)
 	if err != nil {
 		return nil, err
 	}
 
+	l.Items = items
+
 	return l, nil
 }
 
-func (r redisRepository) GetLists(ctx context.Context) ([]*list, error) {
-	// TODO
-	// cmd := r.redis.HGetAll(ctx, fmt.Sprintf(redisFmtList, uuid.String()))
-	// if cmd.Err() != nil {
-	// 	return nil, cmd.Err()
-	// }
-	//
-	// l := new(list)
-	//
-	// err := cmd.Scan(l)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	//
-	// items := new([]*item)
-	//
-	// err = json.Unmarshal([]byte(cmd.Val()["items"]), items)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	return []*list{}, nil
+func (r redisRepository) UpdateList(ctx context.Context, l *list) error {
+	key := fmt.Sprintf(redisFmtList, l.ID.String())
+
+	exists, err := r.redis.Exists(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return svc.ErrNotExists
+	}
+
+	_, err = r.redis.HSet(ctx, key, listToMap(l)).Result()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r redisRepository) DeleteList(ctx context.Context, id *uuid.UUID) error {
+	l, err := r.GetList(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	indexKey := fmt.Sprintf(redisFmtListItems, id.String())
+	collaboratorsKey := fmt.Sprintf(redisFmtListCollaborators, id.String())
+
+	// Watch the item and collaborator indexes so that an item created or a
+	// collaborator added while the list is being deleted aborts the
+	// transaction instead of being left behind.
+	return r.redis.Watch(ctx, func(tx *redis.Tx) error {
+		itemIDs, err := tx.ZRange(ctx, indexKey, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+
+		userIDs, err := tx.ZRange(ctx, collaboratorsKey, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, itemID := range itemIDs {
+				pipe.Del(ctx, fmt.Sprintf(redisFmtItem, itemID))
+				pipe.ZRem(ctx, fmt.Sprintf(redisFmtUserDueItems, l.OwnerID.String()), itemID)
+			}
+			for _, userID := range userIDs {
+				pipe.Del(ctx, fmt.Sprintf(redisFmtListCollaborator, id.String(), userID))
+				pipe.ZRem(ctx, fmt.Sprintf(redisFmtUserSharedLists, userID), id.String())
+			}
+			pipe.Del(ctx, indexKey)
+			pipe.Del(ctx, collaboratorsKey)
+			pipe.Del(ctx, fmt.Sprintf(redisFmtList, id.String()))
+			pipe.ZRem(ctx, fmt.Sprintf(redisFmtUserLists, l.OwnerID.String()), id.String())
+			return nil
+		})
+		return err
+	}, indexKey, collaboratorsKey)
+}
+
+// Fetch the items of a list in the order of the list's item index.
+func (r redisRepository) getListItems(ctx context.Context, listID *uuid.UUID) ([]*item, error) {
+	ids, err := r.redis.ZRange(ctx, fmt.Sprintf(redisFmtListItems, listID.String()), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.getItems(ctx, ids)
+}
+
+// Fetch several items at once, keeping the order of the IDs.
+func (r redisRepository) getItems(ctx context.Context, ids []string) ([]*item, error) {
+	pipe := r.redis.Pipeline()
+
+	cmds := make([]*redis.MapStringStringCmd, 0, len(ids))
+	for _, id := range ids {
+		cmds = append(cmds, pipe.HGetAll(ctx, fmt.Sprintf(redisFmtItem, id)))
+	}
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*item, 0, len(cmds))
+	for _, cmd := range cmds {
+		// Skip index entries whose item no longer exists
+		if len(cmd.Val()) == 0 {
+			continue
+		}
+
+		i := new(item)
+
+		err := cmd.Scan(i)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, i)
+	}
+
+	return items, nil
+}
+
+// Get all the lists owned by a user, most recently created first.
+func (r redisRepository) GetLists(ctx context.Context, ownerID *uuid.UUID) ([]*list, error) {
+	ids, err := r.redis.ZRevRange(ctx, fmt.Sprintf(redisFmtUserLists, ownerID.String()), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	lists := make([]*list, 0, len(ids))
+
+	for _, rawID := range ids {
+		id, err := uuid.Parse(rawID)
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := r.GetList(ctx, &id)
+		if err != nil {
+			return nil, err
+		}
+
+		lists = append(lists, l)
+	}
+
+	return lists, nil
 }
 
 func (r redisRepository) GetItem(ctx context.Context, uuid *uuid.UUID) (*item, error) {
@@ -113,7 +266,7 @@ func (r redisRepository) GetItem(ctx context.Context, uuid *uuid.UUID) (*item, e
 	}
 
 	if len(cmd.Val()) == 0 {
-		return nil, errors.New("Not found")
+		return nil, svc.ErrNotExists
 	}
 
 	i := new(item)
@@ -126,16 +279,48 @@ func (r redisRepository) GetItem(ctx context.Context, uuid *uuid.UUID) (*item, e
 	return i, nil
 }
 
-func (r redisRepository) CreateItem(ctx context.Context, i *item) (*uuid.UUID, error) {
-	m := map[string]any{
+func itemToMap(i *item) map[string]any {
+	return map[string]any{
 		"id":          i.ID.String(),
+		"listId":      i.ListID.String(),
+		"ownerId":     i.OwnerID.String(),
 		"createdAt":   i.CreatedAt,
 		"title":       i.Title,
 		"description": i.Description,
 		"isDone":      bool(i.IsDone),
+		"position":    i.Position,
+		"dueAt":       i.DueAt,
+		"dueTimeZone": i.DueTimeZone,
+	}
+}
+
+// Queue the commands keeping an item's entry in its owner's due date index
+// up to date.
+func indexItemDueDate(ctx context.Context, pipe redis.Pipeliner, i *item) {
+	key := fmt.Sprintf(redisFmtUserDueItems, i.OwnerID.String())
+
+	if !i.hasDueDate() {
+		pipe.ZRem(ctx, key, i.ID.String())
+		return
 	}
 
-	_, err := r.redis.HSet(ctx, fmt.Sprintf(redisFmtItem, i.ID.String()), m).Result()
+	pipe.ZAdd(ctx, key, redis.Z{
+		Score:  float64(i.DueAt.Unix()),
+		Member: i.ID.String(),
+	})
+}
+
+func (r redisRepository) CreateItem(ctx context.Context, i *item) (*uuid.UUID, error) {
+	pipe := r.redis.TxPipeline()
+
+	pipe.HSet(ctx, fmt.Sprintf(redisFmtItem, i.ID.String()), itemToMap(i))
+	pipe.ZAdd(ctx, fmt.Sprintf(redisFmtListItems, i.ListID.String()), redis.Z{
+		Score:  i.Position,
+		Member: i.ID.String(),
+	})
+	indexItemDueDate(ctx, pipe, i)
+
+	_, err := pipe.Exec(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -144,15 +329,35 @@ func (r redisRepository) CreateItem(ctx context.Context, i *item) (*uuid.UUID, e
 }
 
 func (r redisRepository) UpdateItem(ctx context.Context, uuid *uuid.UUID, i *item) error {
-	m := map[string]any{
-		"id":          i.ID.String(),
-		"createdAt":   i.CreatedAt,
-		"title":       i.Title,
-		"description": i.Description,
-		"isDone":      bool(i.IsDone),
+	pipe := r.redis.TxPipeline()
+
+	pipe.HSet(ctx, fmt.Sprintf(redisFmtItem, i.ID.String()), itemToMap(i))
+	pipe.ZAddXX(ctx, fmt.Sprintf(redisFmtListItems, i.ListID.String()), redis.Z{
+		Score:  i.Position,
+		Member: i.ID.String(),
+	})
+	indexItemDueDate(ctx, pipe, i)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return err
 	}
 
-	_, err := r.redis.HSet(ctx, fmt.Sprintf(redisFmtItem, i.ID.String()), m).Result()
+	return nil
+}
+
+func (r redisRepository) UpdateItemPositions(ctx context.Context, items []*item) error {
+	pipe := r.redis.TxPipeline()
+
+	for _, i := range items {
+		pipe.HSet(ctx, fmt.Sprintf(redisFmtItem, i.ID.String()), "position", i.Position)
+		pipe.ZAddXX(ctx, fmt.Sprintf(redisFmtListItems, i.ListID.String()), redis.Z{
+			Score:  i.Position,
+			Member: i.ID.String(),
+		})
+	}
+
+	_, err := pipe.Exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -161,15 +366,190 @@ func (r redisRepository) UpdateItem(ctx context.Context, uuid *uuid.UUID, i *ite
 }
 
 func (r redisRepository) DeleteItem(ctx context.Context, uuid *uuid.UUID) error {
-	status, err := r.redis.Del(ctx, fmt.Sprintf(redisFmtItem, uuid.String())).Result()
+	i, err := r.GetItem(ctx, uuid)
 	if err != nil {
 		return err
 	}
 
-	hasDeletedNothing := status == 0
+	pipe := r.redis.TxPipeline()
+
+	del := pipe.Del(ctx, fmt.Sprintf(redisFmtItem, uuid.String()))
+	pipe.ZRem(ctx, fmt.Sprintf(redisFmtListItems, i.ListID.String()), uuid.String())
+	pipe.ZRem(ctx, fmt.Sprintf(redisFmtUserDueItems, i.OwnerID.String()), uuid.String())
+
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	hasDeletedNothing := del.Val() == 0
 	if hasDeletedNothing {
-		return errors.New("Deleted nothing")
+		return svc.ErrNotExists
 	}
 
 	return nil
 }
+
+func (r redisRepository) GetItemsDue(ctx context.Context, ownerID *uuid.UUID, from time.Time, to time.Time) ([]*item, error) {
+	ids, err := r.redis.ZRangeByScore(ctx, fmt.Sprintf(redisFmtUserDueItems, ownerID.String()), &redis.ZRangeBy{
+		Min: strconv.FormatInt(from.Unix(), 10),
+		Max: strconv.FormatInt(to.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.getItems(ctx, ids)
+}
+
+func collaboratorToMap(c *collaborator) map[string]any {
+	return map[string]any{
+		"listId":  c.ListID.String(),
+		"userId":  c.UserID.String(),
+		"role":    string(c.Role),
+		"addedAt": c.AddedAt,
+	}
+}
+
+func (r redisRepository) SetCollaborator(ctx context.Context, c *collaborator) error {
+	pipe := r.redis.TxPipeline()
+
+	pipe.HSet(ctx, fmt.Sprintf(redisFmtListCollaborator, c.ListID.String(), c.UserID.String()), collaboratorToMap(c))
+	pipe.ZAdd(ctx, fmt.Sprintf(redisFmtListCollaborators, c.ListID.String()), redis.Z{
+		Score:  float64(c.AddedAt.Unix()),
+		Member: c.UserID.String(),
+	})
+	pipe.ZAdd(ctx, fmt.Sprintf(redisFmtUserSharedLists, c.UserID.String()), redis.Z{
+		Score:  float64(c.AddedAt.Unix()),
+		Member: c.ListID.String(),
+	})
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r redisRepository) GetCollaborator(ctx context.Context, listID *uuid.UUID, userID *uuid.UUID) (*collaborator, error) {
+	cmd := r.redis.HGetAll(ctx, fmt.Sprintf(redisFmtListCollaborator, listID.String(), userID.String()))
+	if cmd.Err() != nil {
+		return nil, cmd.Err()
+	}
+
+	if len(cmd.Val()) == 0 {
+		return nil, svc.ErrNotExists
+	}
+
+	c := new(collaborator)
+
+	err := cmd.Scan(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (r redisRepository) GetCollaborators(ctx context.Context, listID *uuid.UUID) ([]*collaborator, error) {
+	userIDs, err := r.redis.ZRange(ctx, fmt.Sprintf(redisFmtListCollaborators, listID.String()), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := r.redis.Pipeline()
+
+	cmds := make([]*redis.MapStringStringCmd, 0, len(userIDs))
+	for _, userID := range userIDs {
+		cmds = append(cmds, pipe.HGetAll(ctx, fmt.Sprintf(redisFmtListCollaborator, listID.String(), userID)))
+	}
+
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	collaborators := make([]*collaborator, 0, len(cmds))
+	for _, cmd := range cmds {
+		// Skip index entries whose collaborator no longer exists
+		if len(cmd.Val()) == 0 {
+			continue
+		}
+
+		c := new(collaborator)
+
+		err := cmd.Scan(c)
+		if err != nil {
+			return nil, err
+		}
+
+		collaborators = append(collaborators, c)
+	}
+
+	return collaborators, nil
+}
+
+func (r redisRepository) RemoveCollaborator(ctx context.Context, listID *uuid.UUID, userID *uuid.UUID) error {
+	pipe := r.redis.TxPipeline()
+
+	del := pipe.Del(ctx, fmt.Sprintf(redisFmtListCollaborator, listID.String(), userID.String()))
+	pipe.ZRem(ctx, fmt.Sprintf(redisFmtListCollaborators, listID.String()), userID.String())
+	pipe.ZRem(ctx, fmt.Sprintf(redisFmtUserSharedLists, userID.String()), listID.String())
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	hasDeletedNothing := del.Val() == 0
+	if hasDeletedNothing {
+		return svc.ErrNotExists
+	}
+
+	return nil
+}
+
+func (r redisRepository) GetSharedLists(ctx context.Context, userID *uuid.UUID) ([]*list, error) {
+	ids, err := r.redis.ZRevRange(ctx, fmt.Sprintf(redisFmtUserSharedLists, userID.String()), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	lists := make([]*list, 0, len(ids))
+
+	for _, rawID := range ids {
+		id, err := uuid.Parse(rawID)
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := r.GetList(ctx, &id)
+		if errors.Is(err, svc.ErrNotExists) {
+			// Skip index entries whose list no longer exists
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		lists = append(lists, l)
+	}
+
+	return lists, nil
+}
+
+func (r redisRepository) RemoveCollaborations(ctx context.Context, userID *uuid.UUID) error {
+	sharedKey := fmt.Sprintf(redisFmtUserSharedLists, userID.String())
+
+	listIDs, err := r.redis.ZRange(ctx, sharedKey, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := r.redis.TxPipeline()
+
+	for _, listID := range listIDs {
+		pipe.Del(ctx, fmt.Sprintf(redisFmtListCollaborator, listID, userID.String()))
+		pipe.ZRem(ctx, fmt.Sprintf(redisFmtListCollaborators, listID), userID.String())
+	}
+	pipe.Del(ctx, sharedKey)
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
@@ -2,55 +2,266 @@ package todo
 
 import (
 	"context"
+	"errors"
+	"log"
+	"time"
 
+	svc "github.com/angelofallars/htmx-chi-todo/service"
 	"github.com/google/uuid"
 )
 
+// Every method takes the ID of the acting user, and fails with
+// svc.ErrForbidden unless that user owns the list or item, or it is shared
+// with them in a role that allows the action.
 type (
 	Service interface {
-		CreateList(ctx context.Context, l *list) (*uuid.UUID, error)
-		GetList(ctx context.Context, id *uuid.UUID) (*list, error)
-		GetLists(ctx context.Context) ([]*list, error)
-		GetItem(ctx context.Context, id *uuid.UUID) (*item, error)
-		CreateItem(ctx context.Context, i *item) (*uuid.UUID, error)
-		UpdateItem(ctx context.Context, id *uuid.UUID, title string, description string) (*item, error)
-		ToggleItemComplete(ctx context.Context, id *uuid.UUID) (isDone, error)
-		DeleteItem(ctx context.Context, id *uuid.UUID) error
+		CreateList(ctx context.Context, userID *uuid.UUID, l *list) (*uuid.UUID, error)
+		CreateExampleList(ctx context.Context, userID *uuid.UUID) (*list, error)
+		GetList(ctx context.Context, userID *uuid.UUID, id *uuid.UUID) (*list, error)
+		// Get the user's lists, either the active or the archived ones.
+		GetLists(ctx context.Context, userID *uuid.UUID, archived bool) ([]*list, error)
+		RenameList(ctx context.Context, userID *uuid.UUID, id *uuid.UUID, title string) (*list, error)
+		SetListArchived(ctx context.Context, userID *uuid.UUID, id *uuid.UUID, archived bool) (*list, error)
+		DeleteList(ctx context.Context, userID *uuid.UUID, id *uuid.UUID) error
+		GetItem(ctx context.Context, userID *uuid.UUID, id *uuid.UUID) (*item, error)
+		CreateItem(ctx context.Context, userID *uuid.UUID, i *item) (*uuid.UUID, error)
+		// Update the details of an item, a zero dueAt clears its due date.
+		UpdateItem(ctx context.Context, userID *uuid.UUID, id *uuid.UUID, title string, description string, dueAt time.Time) (*item, error)
+		ToggleItemComplete(ctx context.Context, userID *uuid.UUID, id *uuid.UUID) (isDone, error)
+		// Update the details and the done state of an item in one write, a
+		// zero dueAt clears its due date.
+		SetItem(ctx context.Context, userID *uuid.UUID, id *uuid.UUID, title string, description string, dueAt time.Time, done isDone) (*item, error)
+		DeleteItem(ctx context.Context, userID *uuid.UUID, id *uuid.UUID) error
+		// Get the user's unfinished items that are due within a time window,
+		// soonest first.
+		GetItemsDue(ctx context.Context, userID *uuid.UUID, from time.Time, to time.Time) ([]*item, error)
+		// Move the items of a list into the order of the given item IDs.
+		ReorderItems(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID, order []uuid.UUID) error
+		// Delete all of the user's lists and items, for when their account
+		// is deleted.
+		DeleteUserData(ctx context.Context, userID *uuid.UUID) error
+		// Receive the events of changes to the items of a list, until ctx
+		// is done.
+		SubscribeList(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID) (<-chan ItemEvent, error)
+		// Share a list with the user of a username or email as an editor
+		// or viewer, or change their role if it already is.
+		ShareList(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID, usernameOrEmail string, role string) (*collaborator, error)
+		// Stop sharing a list with a user. Owners can remove anyone, and
+		// collaborators can remove themselves.
+		UnshareList(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID, collaboratorID *uuid.UUID) error
+		// Get the user's role in a list.
+		GetRole(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID) (Role, error)
+		// Get everyone with access to a list, the owner first.
+		GetCollaborators(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID) ([]*collaborator, error)
+		// Get the lists shared with the user, either the active or the
+		// archived ones.
+		GetSharedLists(ctx context.Context, userID *uuid.UUID, archived bool) ([]*list, error)
+	}
+
+	// Looks up the users lists can be shared with
+	UserDirectory interface {
+		// Fails with svc.ErrNotExists if there is no such user
+		FindUser(ctx context.Context, usernameOrEmail string) (uuid.UUID, error)
+		GetUsername(ctx context.Context, id uuid.UUID) (string, error)
 	}
 
 	service struct {
-		repo Repository
+		repo   Repository
+		events EventBus
+		users  UserDirectory
 	}
 )
 
-func NewService(repository Repository) Service {
+func NewService(repository Repository, events EventBus, users UserDirectory) Service {
 	return service{
-		repo: repository,
+		repo:   repository,
+		events: events,
+		users:  users,
 	}
 }
 
-func (sv service) CreateList(ctx context.Context, l *list) (*uuid.UUID, error) {
+func (sv service) CreateList(ctx context.Context, userID *uuid.UUID, l *list) (*uuid.UUID, error) {
+	if l.OwnerID != *userID {
+		return nil, svc.ErrForbidden
+	}
+
+	title, err := newListTitle(l.Title)
+	if err != nil {
+		return nil, errors.Join(svc.ErrValidation, err)
+	}
+	l.Title = title
+
 	return sv.repo.CreateList(ctx, l)
 }
 
-func (sv service) GetList(ctx context.Context, id *uuid.UUID) (*list, error) {
-	return sv.repo.GetList(ctx, id)
+func (sv service) CreateExampleList(ctx context.Context, userID *uuid.UUID) (*list, error) {
+	l := newExampleList(*userID)
+
+	_, err := sv.CreateList(ctx, userID, l)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range l.Items {
+		_, err := sv.CreateItem(ctx, userID, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+func (sv service) GetList(ctx context.Context, userID *uuid.UUID, id *uuid.UUID) (*list, error) {
+	return sv.getList(ctx, userID, id, RoleViewer)
+}
+
+// Get a list, failing with svc.ErrForbidden unless the user's role in it
+// includes the given one
+func (sv service) getList(ctx context.Context, userID *uuid.UUID, id *uuid.UUID, role Role) (*list, error) {
+	l, err := sv.repo.GetList(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	userRole, err := sv.roleIn(ctx, userID, l)
+	if err != nil {
+		return nil, err
+	}
+
+	if !userRole.includes(role) {
+		return nil, svc.ErrForbidden
+	}
+
+	return l, nil
+}
+
+// The user's role in a list, failing with svc.ErrForbidden if they have none
+func (sv service) roleIn(ctx context.Context, userID *uuid.UUID, l *list) (Role, error) {
+	if l.OwnerID == *userID {
+		return RoleOwner, nil
+	}
+
+	c, err := sv.repo.GetCollaborator(ctx, &l.ID, userID)
+	if errors.Is(err, svc.ErrNotExists) {
+		return "", svc.ErrForbidden
+	} else if err != nil {
+		return "", err
+	}
+
+	return c.Role, nil
+}
+
+func (sv service) GetLists(ctx context.Context, userID *uuid.UUID, archived bool) ([]*list, error) {
+	lists, err := sv.repo.GetLists(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return filterArchived(lists, archived), nil
+}
+
+func filterArchived(lists []*list, archived bool) []*list {
+	filtered := make([]*list, 0, len(lists))
+	for _, l := range lists {
+		if l.IsArchived == archived {
+			filtered = append(filtered, l)
+		}
+	}
+	return filtered
+}
+
+func (sv service) RenameList(ctx context.Context, userID *uuid.UUID, id *uuid.UUID, title string) (*list, error) {
+	l, err := sv.getList(ctx, userID, id, RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	l.Title, err = newListTitle(title)
+	if err != nil {
+		return nil, errors.Join(svc.ErrValidation, err)
+	}
+
+	err = sv.repo.UpdateList(ctx, l)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (sv service) SetListArchived(ctx context.Context, userID *uuid.UUID, id *uuid.UUID, archived bool) (*list, error) {
+	l, err := sv.getList(ctx, userID, id, RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	l.IsArchived = archived
+
+	err = sv.repo.UpdateList(ctx, l)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
 }
 
-func (sv service) GetLists(ctx context.Context) ([]*list, error) {
-	return sv.repo.GetLists(ctx)
+func (sv service) DeleteList(ctx context.Context, userID *uuid.UUID, id *uuid.UUID) error {
+	_, err := sv.getList(ctx, userID, id, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	return sv.repo.DeleteList(ctx, id)
 }
 
-func (sv service) GetItem(ctx context.Context, id *uuid.UUID) (*item, error) {
-	return sv.repo.GetItem(ctx, id)
+func (sv service) GetItem(ctx context.Context, userID *uuid.UUID, id *uuid.UUID) (*item, error) {
+	return sv.getItem(ctx, userID, id, RoleViewer)
 }
 
-func (sv service) CreateItem(ctx context.Context, i *item) (*uuid.UUID, error) {
-	return sv.repo.CreateItem(ctx, i)
+// Get an item, failing with svc.ErrForbidden unless the user's role in its
+// list includes the given one
+func (sv service) getItem(ctx context.Context, userID *uuid.UUID, id *uuid.UUID, role Role) (*item, error) {
+	i, err := sv.repo.GetItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = sv.getList(ctx, userID, &i.ListID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+func (sv service) CreateItem(ctx context.Context, userID *uuid.UUID, i *item) (*uuid.UUID, error) {
+	l, err := sv.getList(ctx, userID, &i.ListID, RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	i.Title, err = newItemTitle(i.Title)
+	if err != nil {
+		return nil, errors.Join(svc.ErrValidation, err)
+	}
+
+	// Items belong to the owner of their list, whoever adds them
+	i.OwnerID = l.OwnerID
+	i.Position = l.nextPosition()
+
+	id, err := sv.repo.CreateItem(ctx, i)
+	if err != nil {
+		return nil, err
+	}
+
+	sv.publish(ctx, EventItemCreated, i.ListID, *id)
+
+	return id, nil
 }
 
-func (sv service) ToggleItemComplete(ctx context.Context, id *uuid.UUID) (isDone, error) {
-	item, err := sv.repo.GetItem(ctx, id)
+func (sv service) ToggleItemComplete(ctx context.Context, userID *uuid.UUID, id *uuid.UUID) (isDone, error) {
+	item, err := sv.getItem(ctx, userID, id, RoleEditor)
 	if err != nil {
 		return false, err
 	}
@@ -63,25 +274,251 @@ func (sv service) ToggleItemComplete(ctx context.Context, id *uuid.UUID) (isDone
 		return false, err
 	}
 
+	sv.publish(ctx, EventItemToggled, item.ListID, *id)
+
 	return newStatus, nil
 }
-func (sv service) UpdateItem(ctx context.Context, id *uuid.UUID, title string, description string) (*item, error) {
-	item, err := sv.repo.GetItem(ctx, id)
+
+func (sv service) UpdateItem(ctx context.Context, userID *uuid.UUID, id *uuid.UUID, title string, description string, dueAt time.Time) (*item, error) {
+	item, err := sv.getItem(ctx, userID, id, RoleEditor)
 	if err != nil {
 		return nil, err
 	}
 
-	item.Title = title
+	item.Title, err = newItemTitle(title)
+	if err != nil {
+		return nil, errors.Join(svc.ErrValidation, err)
+	}
 	item.Description = description
+	item.setDue(dueAt)
 
 	err = sv.repo.UpdateItem(ctx, id, item)
 	if err != nil {
 		return nil, err
 	}
 
+	sv.publish(ctx, EventItemUpdated, item.ListID, *id)
+
 	return item, nil
 }
 
-func (sv service) DeleteItem(ctx context.Context, id *uuid.UUID) error {
-	return sv.repo.DeleteItem(ctx, id)
+func (sv service) SetItem(ctx context.Context, userID *uuid.UUID, id *uuid.UUID, title string, description string, dueAt time.Time, done isDone) (*item, error) {
+	item, err := sv.getItem(ctx, userID, id, RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	item.Title, err = newItemTitle(title)
+	if err != nil {
+		return nil, errors.Join(svc.ErrValidation, err)
+	}
+	item.Description = description
+	item.setDue(dueAt)
+	item.IsDone = done
+
+	err = sv.repo.UpdateItem(ctx, id, item)
+	if err != nil {
+		return nil, err
+	}
+
+	sv.publish(ctx, EventItemUpdated, item.ListID, *id)
+
+	return item, nil
+}
+
+func (sv service) DeleteItem(ctx context.Context, userID *uuid.UUID, id *uuid.UUID) error {
+	item, err := sv.getItem(ctx, userID, id, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	err = sv.repo.DeleteItem(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	sv.publish(ctx, EventItemDeleted, item.ListID, *id)
+
+	return nil
+}
+
+func (sv service) GetItemsDue(ctx context.Context, userID *uuid.UUID, from time.Time, to time.Time) ([]*item, error) {
+	items, err := sv.repo.GetItemsDue(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]*item, 0, len(items))
+	for _, i := range items {
+		if !i.IsDone {
+			pending = append(pending, i)
+		}
+	}
+
+	return pending, nil
+}
+
+func (sv service) ReorderItems(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID, order []uuid.UUID) error {
+	l, err := sv.getList(ctx, userID, listID, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	changed, err := reorderItems(l.Items, order)
+	if err != nil {
+		return errors.Join(svc.ErrValidation, err)
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return sv.repo.UpdateItemPositions(ctx, changed)
+}
+
+func (sv service) DeleteUserData(ctx context.Context, userID *uuid.UUID) error {
+	lists, err := sv.repo.GetLists(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range lists {
+		err := sv.repo.DeleteList(ctx, &l.ID)
+		if err != nil && !errors.Is(err, svc.ErrNotExists) {
+			return err
+		}
+	}
+
+	return sv.repo.RemoveCollaborations(ctx, userID)
+}
+
+func (sv service) SubscribeList(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID) (<-chan ItemEvent, error) {
+	_, err := sv.GetList(ctx, userID, listID)
+	if err != nil {
+		return nil, err
+	}
+
+	return sv.events.Subscribe(ctx, *listID)
+}
+
+func (sv service) ShareList(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID, usernameOrEmail string, role string) (*collaborator, error) {
+	l, err := sv.getList(ctx, userID, listID, RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newCollaboratorRole(role)
+	if err != nil {
+		return nil, errors.Join(svc.ErrValidation, err)
+	}
+
+	collaboratorID, err := sv.users.FindUser(ctx, usernameOrEmail)
+	if errors.Is(err, svc.ErrNotExists) {
+		return nil, errors.Join(svc.ErrValidation, errors.New("There is no user with that username or email"))
+	} else if err != nil {
+		return nil, err
+	}
+
+	if collaboratorID == l.OwnerID {
+		return nil, errors.Join(svc.ErrValidation, errors.New("You can't share a list with its owner"))
+	}
+
+	c, err := sv.repo.GetCollaborator(ctx, listID, &collaboratorID)
+	if errors.Is(err, svc.ErrNotExists) {
+		c = newCollaborator(*listID, collaboratorID, r)
+	} else if err != nil {
+		return nil, err
+	}
+	c.Role = r
+
+	err = sv.repo.SetCollaborator(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Username, err = sv.users.GetUsername(ctx, collaboratorID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (sv service) UnshareList(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID, collaboratorID *uuid.UUID) error {
+	l, err := sv.GetList(ctx, userID, listID)
+	if err != nil {
+		return err
+	}
+
+	isLeaving := *collaboratorID == *userID
+	if l.OwnerID != *userID && !isLeaving {
+		return svc.ErrForbidden
+	}
+
+	return sv.repo.RemoveCollaborator(ctx, listID, collaboratorID)
+}
+
+func (sv service) GetRole(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID) (Role, error) {
+	l, err := sv.repo.GetList(ctx, listID)
+	if err != nil {
+		return "", err
+	}
+
+	return sv.roleIn(ctx, userID, l)
+}
+
+func (sv service) GetCollaborators(ctx context.Context, userID *uuid.UUID, listID *uuid.UUID) ([]*collaborator, error) {
+	l, err := sv.GetList(ctx, userID, listID)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := sv.repo.GetCollaborators(ctx, listID)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := &collaborator{
+		ListID:  l.ID,
+		UserID:  l.OwnerID,
+		Role:    RoleOwner,
+		AddedAt: l.CreatedAt,
+	}
+
+	collaborators := make([]*collaborator, 0, len(shared)+1)
+	for _, c := range append([]*collaborator{owner}, shared...) {
+		c.Username, err = sv.users.GetUsername(ctx, c.UserID)
+		if errors.Is(err, svc.ErrNotExists) {
+			// Deleted since, along with their access
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		collaborators = append(collaborators, c)
+	}
+
+	return collaborators, nil
+}
+
+func (sv service) GetSharedLists(ctx context.Context, userID *uuid.UUID, archived bool) ([]*list, error) {
+	lists, err := sv.repo.GetSharedLists(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return filterArchived(lists, archived), nil
+}
+
+// Let other tabs know about a change. It was already saved, so failing to
+// is only logged.
+func (sv service) publish(ctx context.Context, eventType EventType, listID uuid.UUID, itemID uuid.UUID) {
+	err := sv.events.Publish(ctx, ItemEvent{
+		Type:   eventType,
+		ListID: listID,
+		ItemID: itemID,
+	})
+	if err != nil {
+		log.Printf("publishing %v event of item %v: %v", eventType, itemID, err)
+	}
 }
@@ -0,0 +1,368 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/google/uuid"
+)
+
+// Users by username, for sharing lists without a user package
+type testDirectory map[string]uuid.UUID
+
+func (d testDirectory) FindUser(ctx context.Context, usernameOrEmail string) (uuid.UUID, error) {
+	id, ok := d[usernameOrEmail]
+	if !ok {
+		return uuid.Nil, svc.ErrNotExists
+	}
+	return id, nil
+}
+
+func (d testDirectory) GetUsername(ctx context.Context, id uuid.UUID) (string, error) {
+	for username, userID := range d {
+		if userID == id {
+			return username, nil
+		}
+	}
+	return "", svc.ErrNotExists
+}
+
+func TestServiceEnforcesOwnership(t *testing.T) {
+	sv := NewService(newTestSQLiteRepository(t), NewMemoryEventBus(), testDirectory{})
+	ctx := context.Background()
+
+	owner := uuid.New()
+	stranger := uuid.New()
+
+	l := newList(owner, "Groceries")
+	if _, err := sv.CreateList(ctx, &owner, l); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.CreateList(ctx, &stranger, newList(owner, "Groceries")); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("creating a list for someone else: got %v, want ErrForbidden", err)
+	}
+
+	i := newItem(l.ID, "Study HTMX", "")
+	if _, err := sv.CreateItem(ctx, &owner, i); err != nil {
+		t.Fatal(err)
+	}
+	if i.OwnerID != owner {
+		t.Errorf("got item owner %v, want %v", i.OwnerID, owner)
+	}
+
+	if _, err := sv.CreateItem(ctx, &stranger, newItem(l.ID, "Sneaky", "")); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("creating an item in someone else's list: got %v, want ErrForbidden", err)
+	}
+	if _, err := sv.GetList(ctx, &stranger, &l.ID); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("getting someone else's list: got %v, want ErrForbidden", err)
+	}
+	if _, err := sv.GetItem(ctx, &stranger, &i.ID); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("getting someone else's item: got %v, want ErrForbidden", err)
+	}
+	if _, err := sv.UpdateItem(ctx, &stranger, &i.ID, "Hijacked", "", time.Time{}); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("updating someone else's item: got %v, want ErrForbidden", err)
+	}
+	if _, err := sv.ToggleItemComplete(ctx, &stranger, &i.ID); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("toggling someone else's item: got %v, want ErrForbidden", err)
+	}
+	if err := sv.DeleteItem(ctx, &stranger, &i.ID); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("deleting someone else's item: got %v, want ErrForbidden", err)
+	}
+
+	missing := uuid.New()
+	if _, err := sv.GetItem(ctx, &owner, &missing); !errors.Is(err, svc.ErrNotExists) {
+		t.Errorf("getting a missing item: got %v, want ErrNotExists", err)
+	}
+
+	if err := sv.DeleteItem(ctx, &owner, &i.ID); err != nil {
+		t.Errorf("deleting own item: %v", err)
+	}
+}
+
+func TestDeleteUserData(t *testing.T) {
+	sv := NewService(newTestSQLiteRepository(t), NewMemoryEventBus(), testDirectory{})
+	ctx := context.Background()
+
+	owner := uuid.New()
+	other := uuid.New()
+
+	if _, err := sv.CreateExampleList(ctx, &owner); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := sv.CreateExampleList(ctx, &owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.SetListArchived(ctx, &owner, &archived.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := sv.CreateExampleList(ctx, &other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sv.DeleteUserData(ctx, &owner); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, isArchived := range []bool{false, true} {
+		lists, err := sv.GetLists(ctx, &owner, isArchived)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lists) != 0 {
+			t.Errorf("got %v lists with archived %v, want none", len(lists), isArchived)
+		}
+	}
+
+	if _, err := sv.GetItem(ctx, &owner, &archived.Items[0].ID); !errors.Is(err, svc.ErrNotExists) {
+		t.Errorf("got %v, want the items to be deleted too", err)
+	}
+	if _, err := sv.GetList(ctx, &other, &kept.ID); err != nil {
+		t.Errorf("other user's list: %v", err)
+	}
+}
+
+func TestServiceValidatesItemTitles(t *testing.T) {
+	sv := NewService(newTestSQLiteRepository(t), NewMemoryEventBus(), testDirectory{})
+	ctx := context.Background()
+
+	owner := uuid.New()
+	l := newList(owner, "Groceries")
+	if _, err := sv.CreateList(ctx, &owner, l); err != nil {
+		t.Fatal(err)
+	}
+	i := newItem(l.ID, "Milk", "")
+	if _, err := sv.CreateItem(ctx, &owner, i); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sv.CreateItem(ctx, &owner, newItem(l.ID, "", "")); !errors.Is(err, svc.ErrValidation) {
+		t.Errorf("creating an untitled item: got %v, want ErrValidation", err)
+	}
+	if _, err := sv.UpdateItem(ctx, &owner, &i.ID, "", "", time.Time{}); !errors.Is(err, svc.ErrValidation) {
+		t.Errorf("clearing an item's title: got %v, want ErrValidation", err)
+	}
+	if _, err := sv.SetItem(ctx, &owner, &i.ID, "", "", time.Time{}, true); !errors.Is(err, svc.ErrValidation) {
+		t.Errorf("setting an untitled item: got %v, want ErrValidation", err)
+	}
+
+	got, err := sv.GetItem(ctx, &owner, &i.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Milk" || got.IsDone {
+		t.Errorf("got %+v, want the item unchanged", got)
+	}
+}
+
+func TestDeleteUserDataLeavesSharedLists(t *testing.T) {
+	owner := uuid.New()
+	friend := uuid.New()
+	sv := NewService(newTestSQLiteRepository(t), NewMemoryEventBus(), testDirectory{
+		"owner":  owner,
+		"friend": friend,
+	})
+	ctx := context.Background()
+
+	l := newList(owner, "Groceries")
+	if _, err := sv.CreateList(ctx, &owner, l); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.ShareList(ctx, &owner, &l.ID, "friend", "editor"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sv.DeleteUserData(ctx, &friend); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sv.GetList(ctx, &owner, &l.ID); err != nil {
+		t.Errorf("owner's list: %v", err)
+	}
+	collaborators, err := sv.GetCollaborators(ctx, &owner, &l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collaborators) != 1 {
+		t.Errorf("got %v collaborators, want only the owner", len(collaborators))
+	}
+}
+
+func TestServiceEnforcesRoles(t *testing.T) {
+	owner := uuid.New()
+	editor := uuid.New()
+	viewer := uuid.New()
+	stranger := uuid.New()
+	sv := NewService(newTestSQLiteRepository(t), NewMemoryEventBus(), testDirectory{
+		"owner":  owner,
+		"editor": editor,
+		"viewer": viewer,
+	})
+	ctx := context.Background()
+
+	l := newList(owner, "Groceries")
+	if _, err := sv.CreateList(ctx, &owner, l); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.ShareList(ctx, &owner, &l.ID, "editor", "editor"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.ShareList(ctx, &owner, &l.ID, "viewer", "viewer"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Editors can change items, which stay the owner's
+	i := newItem(l.ID, "Milk", "")
+	if _, err := sv.CreateItem(ctx, &editor, i); err != nil {
+		t.Fatalf("editor creating an item: %v", err)
+	}
+	if i.OwnerID != owner {
+		t.Errorf("got item owner %v, want the list owner %v", i.OwnerID, owner)
+	}
+	if _, err := sv.ToggleItemComplete(ctx, &editor, &i.ID); err != nil {
+		t.Errorf("editor toggling an item: %v", err)
+	}
+	if _, err := sv.RenameList(ctx, &editor, &l.ID, "Shopping"); err != nil {
+		t.Errorf("editor renaming the list: %v", err)
+	}
+
+	// Viewers can only look
+	if _, err := sv.GetList(ctx, &viewer, &l.ID); err != nil {
+		t.Errorf("viewer getting the list: %v", err)
+	}
+	if _, err := sv.GetItem(ctx, &viewer, &i.ID); err != nil {
+		t.Errorf("viewer getting an item: %v", err)
+	}
+	if _, err := sv.SubscribeList(ctx, &viewer, &l.ID); err != nil {
+		t.Errorf("viewer subscribing to the list: %v", err)
+	}
+	if _, err := sv.CreateItem(ctx, &viewer, newItem(l.ID, "Sneaky", "")); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("viewer creating an item: got %v, want ErrForbidden", err)
+	}
+	if _, err := sv.UpdateItem(ctx, &viewer, &i.ID, "Hijacked", "", time.Time{}); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("viewer updating an item: got %v, want ErrForbidden", err)
+	}
+	if _, err := sv.ToggleItemComplete(ctx, &viewer, &i.ID); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("viewer toggling an item: got %v, want ErrForbidden", err)
+	}
+	if _, err := sv.SetItem(ctx, &viewer, &i.ID, "Hijacked", "", time.Time{}, true); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("viewer setting an item: got %v, want ErrForbidden", err)
+	}
+	if err := sv.DeleteItem(ctx, &viewer, &i.ID); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("viewer deleting an item: got %v, want ErrForbidden", err)
+	}
+	if err := sv.ReorderItems(ctx, &viewer, &l.ID, []uuid.UUID{i.ID}); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("viewer reordering items: got %v, want ErrForbidden", err)
+	}
+	if _, err := sv.RenameList(ctx, &viewer, &l.ID, "Mine"); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("viewer renaming the list: got %v, want ErrForbidden", err)
+	}
+
+	// Only the owner can archive, delete and share the list
+	for _, userID := range []uuid.UUID{editor, viewer} {
+		if _, err := sv.SetListArchived(ctx, &userID, &l.ID, true); !errors.Is(err, svc.ErrForbidden) {
+			t.Errorf("collaborator archiving the list: got %v, want ErrForbidden", err)
+		}
+		if err := sv.DeleteList(ctx, &userID, &l.ID); !errors.Is(err, svc.ErrForbidden) {
+			t.Errorf("collaborator deleting the list: got %v, want ErrForbidden", err)
+		}
+		if _, err := sv.ShareList(ctx, &userID, &l.ID, "viewer", "editor"); !errors.Is(err, svc.ErrForbidden) {
+			t.Errorf("collaborator sharing the list: got %v, want ErrForbidden", err)
+		}
+		if err := sv.UnshareList(ctx, &userID, &l.ID, &owner); !errors.Is(err, svc.ErrForbidden) {
+			t.Errorf("collaborator removing the owner: got %v, want ErrForbidden", err)
+		}
+	}
+
+	if _, err := sv.GetList(ctx, &stranger, &l.ID); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("stranger getting the list: got %v, want ErrForbidden", err)
+	}
+}
+
+func TestShareList(t *testing.T) {
+	owner := uuid.New()
+	friend := uuid.New()
+	sv := NewService(newTestSQLiteRepository(t), NewMemoryEventBus(), testDirectory{
+		"owner":  owner,
+		"friend": friend,
+	})
+	ctx := context.Background()
+
+	l := newList(owner, "Groceries")
+	if _, err := sv.CreateList(ctx, &owner, l); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name            string
+		usernameOrEmail string
+		role            string
+	}{
+		{"an unknown user", "nobody", "editor"},
+		{"the owner", "owner", "editor"},
+		{"an unknown role", "friend", "admin"},
+		{"as owner", "friend", "owner"},
+	} {
+		if _, err := sv.ShareList(ctx, &owner, &l.ID, tc.usernameOrEmail, tc.role); !errors.Is(err, svc.ErrValidation) {
+			t.Errorf("sharing with %v: got %v, want ErrValidation", tc.name, err)
+		}
+	}
+
+	c, err := sv.ShareList(ctx, &owner, &l.ID, "friend", "viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Username != "friend" || c.Role != RoleViewer {
+		t.Errorf("got %+v, want friend as a viewer", c)
+	}
+
+	// Sharing again changes the role
+	if _, err := sv.ShareList(ctx, &owner, &l.ID, "friend", "editor"); err != nil {
+		t.Fatal(err)
+	}
+
+	collaborators, err := sv.GetCollaborators(ctx, &friend, &l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collaborators) != 2 {
+		t.Fatalf("got %v collaborators, want the owner and friend", len(collaborators))
+	}
+	if collaborators[0].Username != "owner" || collaborators[0].Role != RoleOwner {
+		t.Errorf("got %+v first, want the owner", collaborators[0])
+	}
+	if collaborators[1].Username != "friend" || collaborators[1].Role != RoleEditor {
+		t.Errorf("got %+v, want friend as an editor", collaborators[1])
+	}
+
+	shared, err := sv.GetSharedLists(ctx, &friend, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 1 || shared[0].ID != l.ID {
+		t.Errorf("got %v shared lists, want the owner's list", len(shared))
+	}
+
+	// Collaborators can leave
+	if err := sv.UnshareList(ctx, &friend, &l.ID, &friend); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.GetList(ctx, &friend, &l.ID); !errors.Is(err, svc.ErrForbidden) {
+		t.Errorf("getting a list after leaving it: got %v, want ErrForbidden", err)
+	}
+
+	// Owners can remove them
+	if _, err := sv.ShareList(ctx, &owner, &l.ID, "friend", "editor"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sv.UnshareList(ctx, &owner, &l.ID, &friend); err != nil {
+		t.Fatal(err)
+	}
+	if err := sv.UnshareList(ctx, &owner, &l.ID, &friend); !errors.Is(err, svc.ErrNotExists) {
+		t.Errorf("removing a removed collaborator: got %v, want ErrNotExists", err)
+	}
+}
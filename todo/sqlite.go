@@ -0,0 +1,525 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/google/uuid"
+)
+
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{
+		db: db,
+	}
+}
+
+func (r SQLiteRepository) CreateList(ctx context.Context, l *list) (*uuid.UUID, error) {
+	query := `INSERT INTO lists( id, ownerId, title, isArchived, createdAt )
+						  values( ?, ?, ?, ?, ? )`
+
+	_, err := r.db.Exec(query,
+		l.ID.String(),
+		l.OwnerID.String(),
+		l.Title,
+		l.IsArchived,
+		l.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &l.ID, nil
+}
+
+func (r SQLiteRepository) GetList(ctx context.Context, id *uuid.UUID) (*list, error) {
+	query := `SELECT ownerId, title, isArchived, createdAt
+			  FROM lists
+			  WHERE id = ?`
+
+	row := r.db.QueryRow(query, id.String())
+
+	var ownerID string
+	var title string
+	var isArchived bool
+	var createdAt int64
+	err := row.Scan(&ownerID, &title, &isArchived, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, svc.ErrNotExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := r.getListItems(id)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &list{
+		ID:         *id,
+		OwnerID:    uuid.MustParse(ownerID),
+		CreatedAt:  time.Unix(createdAt, 0),
+		Title:      title,
+		IsArchived: isArchived,
+		Items:      items,
+	}
+
+	return l, nil
+}
+
+// Get all the lists owned by a user, most recently created first.
+func (r SQLiteRepository) GetLists(ctx context.Context, ownerID *uuid.UUID) ([]*list, error) {
+	query := `SELECT id, title, isArchived, createdAt
+			  FROM lists
+			  WHERE ownerId = ?
+			  ORDER BY createdAt DESC, rowid DESC`
+
+	rows, err := r.db.Query(query, ownerID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := make([]*list, 0)
+	for rows.Next() {
+		var id string
+		var title string
+		var isArchived bool
+		var createdAt int64
+		err := rows.Scan(&id, &title, &isArchived, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		lists = append(lists, &list{
+			ID:         uuid.MustParse(id),
+			OwnerID:    *ownerID,
+			CreatedAt:  time.Unix(createdAt, 0),
+			Title:      title,
+			IsArchived: isArchived,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, l := range lists {
+		l.Items, err = r.getListItems(&l.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return lists, nil
+}
+
+func (r SQLiteRepository) UpdateList(ctx context.Context, l *list) error {
+	query := `UPDATE lists
+			  SET title = ?, isArchived = ?
+			  WHERE id = ?`
+
+	res, err := r.db.Exec(query,
+		l.Title,
+		l.IsArchived,
+		l.ID.String(),
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return svc.ErrNotExists
+	}
+
+	return nil
+}
+
+func (r SQLiteRepository) DeleteList(ctx context.Context, id *uuid.UUID) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM items WHERE listId = ?`, id.String())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM listCollaborators WHERE listId = ?`, id.String())
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`DELETE FROM lists WHERE id = ?`, id.String())
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	hasDeletedNothing := affected == 0
+	if hasDeletedNothing {
+		return svc.ErrNotExists
+	}
+
+	return tx.Commit()
+}
+
+// The columns scanned by scanItem, in order.
+const itemColumns = `id, listId, ownerId, position, title, description, isDone, createdAt, dueAt, dueTimeZone`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanItem(row rowScanner) (*item, error) {
+	var id string
+	var listID string
+	var ownerID string
+	var position float64
+	var title string
+	var description string
+	var done bool
+	var createdAt int64
+	var dueAt sql.NullInt64
+	var dueTimeZone string
+	err := row.Scan(&id, &listID, &ownerID, &position, &title, &description, &done, &createdAt, &dueAt, &dueTimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &item{
+		ID:          uuid.MustParse(id),
+		ListID:      uuid.MustParse(listID),
+		OwnerID:     uuid.MustParse(ownerID),
+		CreatedAt:   time.Unix(createdAt, 0),
+		Position:    position,
+		Title:       title,
+		Description: description,
+		IsDone:      isDone(done),
+		DueTimeZone: dueTimeZone,
+	}
+	if dueAt.Valid {
+		i.DueAt = time.Unix(dueAt.Int64, 0).In(i.dueLocation())
+	}
+
+	return i, nil
+}
+
+func scanItems(rows *sql.Rows) ([]*item, error) {
+	defer rows.Close()
+
+	items := make([]*item, 0)
+	for rows.Next() {
+		i, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+
+	return items, rows.Err()
+}
+
+// The due date of an item as stored in the dueAt column, NULL if it has none.
+func dueAtColumn(i *item) sql.NullInt64 {
+	if !i.hasDueDate() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: i.DueAt.Unix(), Valid: true}
+}
+
+// Fetch the items of a list in the order of their positions.
+func (r SQLiteRepository) getListItems(listID *uuid.UUID) ([]*item, error) {
+	query := `SELECT ` + itemColumns + `
+			  FROM items
+			  WHERE listId = ?
+			  ORDER BY position, createdAt, rowid`
+
+	rows, err := r.db.Query(query, listID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return scanItems(rows)
+}
+
+func (r SQLiteRepository) GetItem(ctx context.Context, id *uuid.UUID) (*item, error) {
+	query := `SELECT ` + itemColumns + `
+			  FROM items
+			  WHERE id = ?`
+
+	row := r.db.QueryRow(query, id.String())
+
+	i, err := scanItem(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, svc.ErrNotExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+func (r SQLiteRepository) CreateItem(ctx context.Context, i *item) (*uuid.UUID, error) {
+	query := `INSERT INTO items( ` + itemColumns + ` )
+						  values( ?, ?, ?, ?, ?, ?, ?, ?, ?, ? )`
+
+	_, err := r.db.Exec(query,
+		i.ID.String(),
+		i.ListID.String(),
+		i.OwnerID.String(),
+		i.Position,
+		i.Title,
+		i.Description,
+		bool(i.IsDone),
+		i.CreatedAt.Unix(),
+		dueAtColumn(i),
+		i.DueTimeZone,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &i.ID, nil
+}
+
+func (r SQLiteRepository) UpdateItem(ctx context.Context, uuid *uuid.UUID, i *item) error {
+	query := `UPDATE items
+			  SET position = ?, title = ?, description = ?, isDone = ?, dueAt = ?, dueTimeZone = ?
+			  WHERE id = ?`
+
+	res, err := r.db.Exec(query,
+		i.Position,
+		i.Title,
+		i.Description,
+		bool(i.IsDone),
+		dueAtColumn(i),
+		i.DueTimeZone,
+		uuid.String(),
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return svc.ErrNotExists
+	}
+
+	return nil
+}
+
+func (r SQLiteRepository) UpdateItemPositions(ctx context.Context, items []*item) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, i := range items {
+		_, err := tx.Exec(`UPDATE items SET position = ? WHERE id = ?`, i.Position, i.ID.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r SQLiteRepository) DeleteItem(ctx context.Context, uuid *uuid.UUID) error {
+	res, err := r.db.Exec(`DELETE FROM items WHERE id = ?`, uuid.String())
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	hasDeletedNothing := affected == 0
+	if hasDeletedNothing {
+		return svc.ErrNotExists
+	}
+
+	return nil
+}
+
+func (r SQLiteRepository) GetItemsDue(ctx context.Context, ownerID *uuid.UUID, from time.Time, to time.Time) ([]*item, error) {
+	query := `SELECT ` + itemColumns + `
+			  FROM items
+			  WHERE ownerId = ? AND dueAt BETWEEN ? AND ?
+			  ORDER BY dueAt, rowid`
+
+	rows, err := r.db.Query(query, ownerID.String(), from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	return scanItems(rows)
+}
+
+func (r SQLiteRepository) SetCollaborator(ctx context.Context, c *collaborator) error {
+	query := `INSERT INTO listCollaborators( listId, userId, role, addedAt )
+						  values( ?, ?, ?, ? )
+			  ON CONFLICT( listId, userId ) DO UPDATE SET role = excluded.role`
+
+	_, err := r.db.Exec(query,
+		c.ListID.String(),
+		c.UserID.String(),
+		string(c.Role),
+		c.AddedAt.Unix(),
+	)
+	return err
+}
+
+func (r SQLiteRepository) GetCollaborator(ctx context.Context, listID *uuid.UUID, userID *uuid.UUID) (*collaborator, error) {
+	query := `SELECT role, addedAt
+			  FROM listCollaborators
+			  WHERE listId = ? AND userId = ?`
+
+	row := r.db.QueryRow(query, listID.String(), userID.String())
+
+	var role string
+	var addedAt int64
+	err := row.Scan(&role, &addedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, svc.ErrNotExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &collaborator{
+		ListID:  *listID,
+		UserID:  *userID,
+		Role:    Role(role),
+		AddedAt: time.Unix(addedAt, 0),
+	}
+
+	return c, nil
+}
+
+func (r SQLiteRepository) GetCollaborators(ctx context.Context, listID *uuid.UUID) ([]*collaborator, error) {
+	query := `SELECT userId, role, addedAt
+			  FROM listCollaborators
+			  WHERE listId = ?
+			  ORDER BY addedAt, rowid`
+
+	rows, err := r.db.Query(query, listID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := make([]*collaborator, 0)
+	for rows.Next() {
+		var userID string
+		var role string
+		var addedAt int64
+		err := rows.Scan(&userID, &role, &addedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		collaborators = append(collaborators, &collaborator{
+			ListID:  *listID,
+			UserID:  uuid.MustParse(userID),
+			Role:    Role(role),
+			AddedAt: time.Unix(addedAt, 0),
+		})
+	}
+
+	return collaborators, rows.Err()
+}
+
+func (r SQLiteRepository) RemoveCollaborator(ctx context.Context, listID *uuid.UUID, userID *uuid.UUID) error {
+	res, err := r.db.Exec(`DELETE FROM listCollaborators WHERE listId = ? AND userId = ?`, listID.String(), userID.String())
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	hasDeletedNothing := affected == 0
+	if hasDeletedNothing {
+		return svc.ErrNotExists
+	}
+
+	return nil
+}
+
+func (r SQLiteRepository) GetSharedLists(ctx context.Context, userID *uuid.UUID) ([]*list, error) {
+	query := `SELECT lists.id, lists.ownerId, lists.title, lists.isArchived, lists.createdAt
+			  FROM listCollaborators
+			  JOIN lists ON lists.id = listCollaborators.listId
+			  WHERE listCollaborators.userId = ?
+			  ORDER BY listCollaborators.addedAt DESC, listCollaborators.rowid DESC`
+
+	rows, err := r.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := make([]*list, 0)
+	for rows.Next() {
+		var id string
+		var ownerID string
+		var title string
+		var isArchived bool
+		var createdAt int64
+		err := rows.Scan(&id, &ownerID, &title, &isArchived, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		lists = append(lists, &list{
+			ID:         uuid.MustParse(id),
+			OwnerID:    uuid.MustParse(ownerID),
+			CreatedAt:  time.Unix(createdAt, 0),
+			Title:      title,
+			IsArchived: isArchived,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, l := range lists {
+		l.Items, err = r.getListItems(&l.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return lists, nil
+}
+
+func (r SQLiteRepository) RemoveCollaborations(ctx context.Context, userID *uuid.UUID) error {
+	_, err := r.db.Exec(`DELETE FROM listCollaborators WHERE userId = ?`, userID.String())
+	return err
+}
@@ -0,0 +1,238 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/angelofallars/htmx-chi-todo/migrate"
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestSQLiteRepository(t *testing.T) *SQLiteRepository {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to ":memory:" opens a separate database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, migrate.Migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSQLiteRepository(db)
+}
+
+func TestSQLiteRepositoryLists(t *testing.T) {
+	var repo Repository = newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	owner := uuid.New()
+	older := newList(owner, "Groceries")
+	older.CreatedAt = time.Now().Add(-time.Hour)
+	newer := newList(owner, "Chores")
+	someoneElses := newList(uuid.New(), "Errands")
+
+	for _, l := range []*list{older, newer, someoneElses} {
+		if _, err := repo.CreateList(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := newItem(newer.ID, "First", "")
+	second := newItem(newer.ID, "Second", "with a description")
+	for _, i := range []*item{first, second} {
+		if _, err := repo.CreateItem(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lists, err := repo.GetLists(ctx, &owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lists) != 2 {
+		t.Fatalf("got %v lists, want 2", len(lists))
+	}
+	if lists[0].ID != newer.ID || lists[1].ID != older.ID {
+		t.Errorf("lists are not ordered by most recently created")
+	}
+
+	l, err := repo.GetList(ctx, &newer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Items) != 2 || l.Items[0].ID != first.ID || l.Items[1].ID != second.ID {
+		t.Errorf("list items are not hydrated in creation order")
+	}
+
+	missing := uuid.New()
+	if _, err := repo.GetList(ctx, &missing); err == nil {
+		t.Errorf("expected an error getting a missing list")
+	}
+
+	if err := repo.DeleteList(ctx, &newer.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetItem(ctx, &first.ID); err == nil {
+		t.Errorf("expected the items of a deleted list to be deleted")
+	}
+}
+
+func TestSQLiteRepositoryItems(t *testing.T) {
+	var repo Repository = newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	l := newList(uuid.New(), "Groceries")
+	if _, err := repo.CreateList(ctx, l); err != nil {
+		t.Fatal(err)
+	}
+
+	i := newItem(l.ID, "Study HTMX", "")
+	if _, err := repo.CreateItem(ctx, i); err != nil {
+		t.Fatal(err)
+	}
+
+	i.Title = "Study templ"
+	i.IsDone = true
+	if err := repo.UpdateItem(ctx, &i.ID, i); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.GetItem(ctx, &i.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Study templ" || !got.IsDone || got.ListID != l.ID {
+		t.Errorf("got %+v, want the updated item", got)
+	}
+
+	if err := repo.DeleteItem(ctx, &i.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteItem(ctx, &i.ID); err == nil {
+		t.Errorf("expected an error deleting an item twice")
+	}
+	if _, err := repo.GetItem(ctx, &i.ID); err == nil {
+		t.Errorf("expected an error getting a deleted item")
+	}
+}
+
+func TestSQLiteRepositoryItemsDue(t *testing.T) {
+	var repo Repository = newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	owner := uuid.New()
+	l := newList(owner, "Groceries")
+	if _, err := repo.CreateList(ctx, l); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	soon := newItem(l.ID, "Soon", "")
+	soon.setDue(now.Add(time.Hour))
+	later := newItem(l.ID, "Later", "")
+	later.setDue(now.Add(48 * time.Hour))
+	whenever := newItem(l.ID, "Whenever", "")
+
+	for _, i := range []*item{later, soon, whenever} {
+		i.OwnerID = owner
+		if _, err := repo.CreateItem(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err := repo.GetItemsDue(ctx, &owner, now, now.Add(72*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].ID != soon.ID || items[1].ID != later.ID {
+		t.Fatalf("got %v items, want the two items with due dates, soonest first", len(items))
+	}
+
+	items, err = repo.GetItemsDue(ctx, &owner, now, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ID != soon.ID {
+		t.Fatalf("got %v items, want only the item due within the window", len(items))
+	}
+	if !items[0].DueAt.Equal(soon.DueAt.Truncate(time.Second)) {
+		t.Errorf("got due date %v, want %v", items[0].DueAt, soon.DueAt)
+	}
+}
+
+func TestSQLiteRepositoryCollaborators(t *testing.T) {
+	var repo Repository = newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	l := newList(uuid.New(), "Groceries")
+	if _, err := repo.CreateList(ctx, l); err != nil {
+		t.Fatal(err)
+	}
+
+	editor := newCollaborator(l.ID, uuid.New(), RoleEditor)
+	viewer := newCollaborator(l.ID, uuid.New(), RoleViewer)
+	viewer.AddedAt = editor.AddedAt.Add(time.Minute)
+	for _, c := range []*collaborator{editor, viewer} {
+		if err := repo.SetCollaborator(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Setting a collaborator again changes their role
+	viewer.Role = RoleEditor
+	if err := repo.SetCollaborator(ctx, viewer); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.GetCollaborator(ctx, &l.ID, &viewer.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Role != RoleEditor {
+		t.Errorf("got role %v, want %v", got.Role, RoleEditor)
+	}
+
+	collaborators, err := repo.GetCollaborators(ctx, &l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collaborators) != 2 || collaborators[0].UserID != editor.UserID || collaborators[1].UserID != viewer.UserID {
+		t.Errorf("collaborators are not ordered by when they were added")
+	}
+
+	shared, err := repo.GetSharedLists(ctx, &editor.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 1 || shared[0].ID != l.ID || shared[0].OwnerID != l.OwnerID {
+		t.Errorf("got %+v, want the shared list", shared)
+	}
+
+	if err := repo.RemoveCollaborations(ctx, &editor.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetCollaborator(ctx, &l.ID, &editor.UserID); !errors.Is(err, svc.ErrNotExists) {
+		t.Errorf("got %v, want the collaboration to be removed", err)
+	}
+
+	if err := repo.DeleteList(ctx, &l.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RemoveCollaborator(ctx, &l.ID, &viewer.UserID); !errors.Is(err, svc.ErrNotExists) {
+		t.Errorf("got %v, want the collaborators of a deleted list to be removed", err)
+	}
+}
@@ -0,0 +1,57 @@
+package todo
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestListShowsControlsByRole(t *testing.T) {
+	l := newList(uuid.New(), "Groceries")
+	l.Items = []*item{newItem(l.ID, "Milk", "")}
+
+	tests := []struct {
+		role Role
+		// Parts of the HTML that only some roles get
+		want    []string
+		notWant []string
+	}{
+		{
+			role:    RoleViewer,
+			notWant: []string{"/order", "/items\"", "hx-delete", "/toggle", "cursor-text", "/archive"},
+		},
+		{
+			role:    RoleEditor,
+			want:    []string{"/order", "/items\"", "hx-delete", "/toggle", "cursor-text", `hx-target="closest li"`},
+			notWant: []string{"/archive"},
+		},
+		{
+			role: RoleOwner,
+			want: []string{"/order", "/items\"", "hx-delete", "/toggle", "cursor-text", `hx-target="closest li"`, "/archive"},
+		},
+	}
+
+	for _, tt := range tests {
+		var b strings.Builder
+		if err := l.header(tt.role).Render(context.Background(), &b); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Component(tt.role).Render(context.Background(), &b); err != nil {
+			t.Fatal(err)
+		}
+		html := b.String()
+
+		for _, s := range tt.want {
+			if !strings.Contains(html, s) {
+				t.Errorf("%v: want %q in the HTML", tt.role, s)
+			}
+		}
+		for _, s := range tt.notWant {
+			if strings.Contains(html, s) {
+				t.Errorf("%v: got %q in the HTML, want it left out", tt.role, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// api.go provides the JSON API for logging in, for clients other than the
+// browser
+package user
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/angelofallars/htmx-chi-todo/auth"
+	"github.com/angelofallars/htmx-chi-todo/openapi"
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/google/uuid"
+)
+
+// These are the DTOs (data transfer objects)
+type (
+	TokenReq struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	RefreshTokenReq struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	TokenResponse struct {
+		// Always "Bearer"
+		TokenType             string    `json:"tokenType"`
+		AccessToken           string    `json:"accessToken"`
+		AccessTokenExpiresAt  time.Time `json:"accessTokenExpiresAt"`
+		RefreshToken          string    `json:"refreshToken"`
+		RefreshTokenExpiresAt time.Time `json:"refreshTokenExpiresAt"`
+	}
+
+	UserDTO struct {
+		ID              uuid.UUID  `json:"id"`
+		Username        string     `json:"username"`
+		Email           string     `json:"email"`
+		CreatedAt       time.Time  `json:"createdAt"`
+		EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty"`
+	}
+)
+
+func newTokenResponse(pair *auth.TokenPair) TokenResponse {
+	return TokenResponse{
+		TokenType:             "Bearer",
+		AccessToken:           pair.AccessToken,
+		AccessTokenExpiresAt:  pair.AccessTokenExpiresAt,
+		RefreshToken:          pair.RefreshToken,
+		RefreshTokenExpiresAt: pair.RefreshTokenExpiresAt,
+	}
+}
+
+func (u User) dto() UserDTO {
+	dto := UserDTO{
+		ID:        u.ID,
+		Username:  string(u.Username),
+		Email:     string(u.Email),
+		CreatedAt: u.CreatedAt,
+	}
+	if u.IsEmailVerified() {
+		dto.EmailVerifiedAt = &u.EmailVerifiedAt
+	}
+	return dto
+}
+
+type (
+	APIHandler interface {
+		svc.HandlerMounter
+		openapi.Describer
+	}
+
+	apiHandler struct {
+		service Service
+	}
+)
+
+func NewAPIHandler(service Service) APIHandler {
+	return &apiHandler{
+		service: service,
+	}
+}
+
+func (h apiHandler) Mount(r chi.Router) {
+	r.Post("/token", h.Token)
+	r.Post("/token/refresh", h.RefreshToken)
+	r.Post("/token/revoke", h.RevokeToken)
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireToken)
+
+		r.Get("/me", h.Me)
+	})
+}
+
+func (h apiHandler) Routes() []openapi.Route {
+	return []openapi.Route{
+		{
+			Method: http.MethodPost, Path: "/token", Tag: "auth", Public: true,
+			Summary: "Log in with a username and password",
+			Request: TokenReq{}, Response: TokenResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/token/refresh", Tag: "auth", Public: true,
+			Summary: "Exchange a refresh token for new tokens, after which it stops working",
+			Request: RefreshTokenReq{}, Response: TokenResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/token/revoke", Tag: "auth", Public: true,
+			Summary: "Log out, revoking the refresh token and the bearer token if sent",
+			Request: RefreshTokenReq{}, Status: http.StatusNoContent,
+		},
+		{
+			Method: http.MethodGet, Path: "/me", Tag: "auth",
+			Summary:  "Get the logged in user",
+			Response: UserDTO{},
+		},
+	}
+}
+
+// Log in with a username and password
+func (h apiHandler) Token(w http.ResponseWriter, r *http.Request) {
+	var req TokenReq
+	err := svc.DecodeJSON(w, r, &req)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	tokens, err := h.service.Login(r.Context(), LoginReq{
+		Username:    req.Username,
+		RawPassword: req.Password,
+		IP:          remoteIP(r),
+	})
+	if err != nil {
+		svc.WriteJSONErrorCode(w, loginStatusCode(w, err), err)
+		return
+	}
+
+	svc.WriteJSON(w, http.StatusOK, newTokenResponse(tokens))
+}
+
+func (h apiHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshTokenReq
+	err := svc.DecodeJSON(w, r, &req)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	tokens, err := h.service.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	svc.WriteJSON(w, http.StatusOK, newTokenResponse(tokens))
+}
+
+// Log out, ending the session of the refresh token and revoking the access
+// token in the Authorization header, if any
+func (h apiHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshTokenReq
+	err := svc.DecodeJSON(w, r, &req)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	err = h.service.Logout(r.Context(), jwtauth.TokenFromHeader(r), req.RefreshToken)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h apiHandler) Me(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.JwtClaimsFromRequest(r)
+	if err != nil {
+		svc.WriteJSONError(w, errors.Join(svc.ErrUnauthorized, err))
+		return
+	}
+
+	u, err := h.service.GetAccount(r.Context(), claims.ID)
+	if err != nil {
+		svc.WriteJSONError(w, err)
+		return
+	}
+
+	svc.WriteJSON(w, http.StatusOK, u.dto())
+}
@@ -0,0 +1,62 @@
+// directory.go lets other packages look up users, such as to share todo
+// lists with them
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+type Directory struct {
+	repo Repository
+}
+
+func NewDirectory(repo Repository) *Directory {
+	return &Directory{
+		repo: repo,
+	}
+}
+
+// Find a user by their username or email, failing with service.ErrNotExists
+// if there is none
+func (d Directory) FindUser(ctx context.Context, usernameOrEmail string) (uuid.UUID, error) {
+	usernameOrEmail = strings.TrimSpace(usernameOrEmail)
+
+	var u *User
+	var err error
+	if email, emailErr := NewEmail(usernameOrEmail); emailErr == nil {
+		u, err = d.repo.GetUserByEmail(ctx, email)
+	} else {
+		u, err = d.repo.GetUserByUsername(ctx, Username(usernameOrEmail))
+	}
+	if isMissingUser(err) {
+		return uuid.Nil, errors.Join(service.ErrNotExists, err)
+	} else if err != nil {
+		return uuid.Nil, err
+	}
+
+	return u.ID, nil
+}
+
+func (d Directory) GetUsername(ctx context.Context, id uuid.UUID) (string, error) {
+	u, err := d.repo.GetUserByID(ctx, id)
+	if isMissingUser(err) {
+		return "", errors.Join(service.ErrNotExists, err)
+	} else if err != nil {
+		return "", err
+	}
+
+	return string(u.Username), nil
+}
+
+// Whether a repository error means there is no such user, rather than the
+// lookup failing
+func isMissingUser(err error) bool {
+	return errors.Is(err, ErrNotExists) || errors.Is(err, sql.ErrNoRows) || errors.Is(err, redis.Nil)
+}
@@ -2,9 +2,14 @@ package user
 
 import (
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
+	"github.com/angelofallars/htmx-chi-todo/auth"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -16,6 +21,8 @@ type User struct {
 	Username       Username       `redis:"username"`
 	Email          Email          `redis:"email"`
 	HashedPassword HashedPassword `redis:"hashedPassword"`
+	// Zero until the user opens the link sent to their email
+	EmailVerifiedAt time.Time `redis:"emailVerifiedAt"`
 }
 
 func NewUser(username Username, hashedPassword HashedPassword, email Email) *User {
@@ -28,6 +35,10 @@ func NewUser(username Username, hashedPassword HashedPassword, email Email) *Use
 	}
 }
 
+func (u User) IsEmailVerified() bool {
+	return !u.EmailVerifiedAt.IsZero()
+}
+
 type Username = string
 
 func NewUsername(un string) (Username, error) {
@@ -89,3 +100,39 @@ func NewHashedPassword(rawPassword string) (HashedPassword, error) {
 
 	return HashedPassword(string(hp)), nil
 }
+
+// The most API tokens a user can have at once
+const maxAPITokens = 20
+
+func newAPITokenName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+
+	if name == "" {
+		return "", errors.New("Token name must not be empty")
+	}
+
+	if utf8.RuneCountInString(name) > 50 {
+		return "", errors.New("Token name must be at most 50 characters")
+	}
+
+	return name, nil
+}
+
+func newAPITokenScopes(rawScopes []string) ([]auth.Scope, error) {
+	if len(rawScopes) == 0 {
+		return nil, errors.New("Token must have at least one scope")
+	}
+
+	scopes := make([]auth.Scope, 0, len(rawScopes))
+	for _, raw := range rawScopes {
+		scope, err := auth.ParseScope(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Unknown scope %q", raw)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
@@ -2,8 +2,13 @@ package user
 
 import (
 	"errors"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 
+	"github.com/angelofallars/htmx-chi-todo/auth"
+	"github.com/angelofallars/htmx-chi-todo/ratelimit"
 	"github.com/angelofallars/htmx-chi-todo/service"
 	svc "github.com/angelofallars/htmx-chi-todo/service"
 	"github.com/angelofallars/htmx-chi-todo/site"
@@ -18,10 +23,27 @@ type (
 		Signup(w http.ResponseWriter, r *http.Request)
 		LoginPage(w http.ResponseWriter, r *http.Request)
 		Login(w http.ResponseWriter, r *http.Request)
+		Logout(w http.ResponseWriter, r *http.Request)
+		LogoutAll(w http.ResponseWriter, r *http.Request)
+		VerifySentPage(w http.ResponseWriter, r *http.Request)
+		VerifyEmail(w http.ResponseWriter, r *http.Request)
+		ForgotPasswordPage(w http.ResponseWriter, r *http.Request)
+		ForgotPassword(w http.ResponseWriter, r *http.Request)
+		ResetSentPage(w http.ResponseWriter, r *http.Request)
+		ResetPasswordPage(w http.ResponseWriter, r *http.Request)
+		ResetPassword(w http.ResponseWriter, r *http.Request)
+		AccountPage(w http.ResponseWriter, r *http.Request)
+		ChangeUsername(w http.ResponseWriter, r *http.Request)
+		ChangeEmail(w http.ResponseWriter, r *http.Request)
+		ChangePassword(w http.ResponseWriter, r *http.Request)
+		DeleteAccount(w http.ResponseWriter, r *http.Request)
+		CreateAPIToken(w http.ResponseWriter, r *http.Request)
+		RevokeAPIToken(w http.ResponseWriter, r *http.Request)
 	}
 
 	handler struct {
 		service Service
+		cookies auth.Cookies
 	}
 )
 
@@ -30,11 +52,33 @@ func (h handler) Mount(r chi.Router) {
 	r.Post("/signup", h.Signup)
 	r.Get("/login", h.LoginPage)
 	r.Post("/login", h.Login)
+	r.Post("/logout", h.Logout)
+	r.Post("/logout/all", h.LogoutAll)
+	r.Get("/verify", h.VerifyEmail)
+	r.Get("/verify/sent", h.VerifySentPage)
+	r.Get("/forgot-password", h.ForgotPasswordPage)
+	r.Post("/forgot-password", h.ForgotPassword)
+	r.Get("/forgot-password/sent", h.ResetSentPage)
+	r.Get("/reset-password", h.ResetPasswordPage)
+	r.Post("/reset-password", h.ResetPassword)
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireLogin)
+
+		r.Get("/account", h.AccountPage)
+		r.Post("/account/username", h.ChangeUsername)
+		r.Post("/account/email", h.ChangeEmail)
+		r.Post("/account/password", h.ChangePassword)
+		r.Post("/account/delete", h.DeleteAccount)
+		r.Post("/account/tokens", h.CreateAPIToken)
+		r.Post("/account/tokens/{id}/revoke", h.RevokeAPIToken)
+	})
 }
 
-func NewHandler(service Service) Handler {
+func NewHandler(service Service, cookies auth.Cookies) Handler {
 	return &handler{
 		service: service,
+		cookies: cookies,
 	}
 }
 
@@ -48,7 +92,7 @@ func (h handler) SignupPage(w http.ResponseWriter, r *http.Request) {
 func (h handler) LoginPage(w http.ResponseWriter, r *http.Request) {
 	site.RenderRootOrPartial(w, r,
 		"Login",
-		loginPage(),
+		loginPage(auth.SafeNext(r.URL.Query().Get("next"))),
 	)
 }
 
@@ -77,38 +121,358 @@ func (h handler) Signup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	htmx.NewResponse().
-		Redirect("login").
+		Redirect("/verify/sent").
 		Write(w)
 }
 
+func (h handler) VerifySentPage(w http.ResponseWriter, r *http.Request) {
+	site.RenderRootOrPartial(w, r,
+		"Check Your Email",
+		verifySentPage(),
+	)
+}
+
+// Opened from the link emailed to the user
+func (h handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	err := h.service.VerifyEmail(r.Context(), r.URL.Query().Get("token"))
+	if err != nil && !errors.Is(err, ErrInvalidVerificationToken) {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+	if err != nil {
+		// Without the validation error prefix
+		err = ErrInvalidVerificationToken
+	}
+
+	site.RenderRootOrPartial(w, r,
+		"Verify Email",
+		verifyResultPage(err),
+	)
+}
+
 func (h handler) Login(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	username := r.Form.Get("username")
 	password := r.Form.Get("password")
 
-	jwt, err := h.service.Login(r.Context(), LoginReq{
+	tokens, err := h.service.Login(r.Context(), LoginReq{
 		Username:    username,
 		RawPassword: password,
+		IP:          remoteIP(r),
 	})
 
 	if err != nil {
-		code := http.StatusInternalServerError
-		if errors.Is(err, service.ErrValidation) {
-			code = http.StatusBadRequest
-		}
 		site.RenderError(w,
-			code,
+			loginStatusCode(w, err),
 			err,
 		)
 		return
 	}
 
+	h.cookies.SetSession(w, tokens)
+	site.ResetCSRFToken(w, h.cookies)
+
+	htmx.NewResponse().
+		Redirect(auth.SafeNext(r.Form.Get("next"))).
+		Write(w)
+}
+
+// The status code of a failed login. Lockouts also set the Retry-After
+// header.
+func loginStatusCode(w http.ResponseWriter, err error) int {
+	var lockedErr *ratelimit.LockedError
+	if errors.As(err, &lockedErr) {
+		seconds := int(math.Ceil(lockedErr.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+
+	switch {
+	case errors.Is(err, ErrIncorrectLogin):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrEmailNotVerified):
+		return http.StatusForbidden
+	default:
+		return svc.StatusCode(err)
+	}
+}
+
+// The IP address of the client, without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (h handler) Logout(w http.ResponseWriter, r *http.Request) {
+	accessToken, refreshToken := auth.SessionCookies(r)
+
+	err := h.service.Logout(r.Context(), accessToken, refreshToken)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	h.cookies.ClearSession(w)
+	site.ResetCSRFToken(w, h.cookies)
+
 	htmx.NewResponse().
-		Redirect("/").
+		Redirect("/login").
 		Write(w)
+}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:  "jwt",
-		Value: jwt,
+// Log out of every device, including this one
+func (h handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.JwtClaimsFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	err = h.service.LogoutAll(r.Context(), claims.ID)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	h.cookies.ClearSession(w)
+	site.ResetCSRFToken(w, h.cookies)
+
+	htmx.NewResponse().
+		Redirect("/login").
+		Write(w)
+}
+
+func (h handler) ForgotPasswordPage(w http.ResponseWriter, r *http.Request) {
+	site.RenderRootOrPartial(w, r,
+		"Forgot Password",
+		forgotPasswordPage(),
+	)
+}
+
+func (h handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	err := h.service.ForgotPassword(r.Context(), r.Form.Get("email"))
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	htmx.NewResponse().
+		Redirect("/forgot-password/sent").
+		Write(w)
+}
+
+func (h handler) ResetSentPage(w http.ResponseWriter, r *http.Request) {
+	site.RenderRootOrPartial(w, r,
+		"Check Your Email",
+		resetSentPage(),
+	)
+}
+
+// Opened from the link emailed to the user
+func (h handler) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	site.RenderRootOrPartial(w, r,
+		"Reset Password",
+		resetPasswordPage(r.URL.Query().Get("token")),
+	)
+}
+
+func (h handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	err := h.service.ResetPassword(r.Context(), ResetPasswordReq{
+		Token:       r.Form.Get("token"),
+		RawPassword: r.Form.Get("password"),
 	})
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	// Every session was ended, including any in this browser
+	h.cookies.ClearSession(w)
+	site.ResetCSRFToken(w, h.cookies)
+
+	htmx.NewResponse().
+		Redirect("/login").
+		Write(w)
+}
+
+func (h handler) AccountPage(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.JwtClaimsFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	u, err := h.service.GetAccount(r.Context(), claims.ID)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	tokens, err := h.service.GetAPITokens(r.Context(), claims.ID)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	site.RenderRootOrPartial(w, r,
+		"Account",
+		accountPage(u, tokens),
+	)
+}
+
+func (h handler) ChangeUsername(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.JwtClaimsFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	r.ParseForm()
+	err = h.service.ChangeUsername(r.Context(), ChangeUsernameReq{
+		UserID:   claims.ID,
+		Username: r.Form.Get("username"),
+	})
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	h.loggedOut(w)
+}
+
+func (h handler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.JwtClaimsFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	r.ParseForm()
+	err = h.service.ChangeEmail(r.Context(), ChangeEmailReq{
+		UserID:      claims.ID,
+		Email:       r.Form.Get("email"),
+		RawPassword: r.Form.Get("password"),
+	})
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	htmx.NewResponse().
+		Redirect("/account").
+		Write(w)
+}
+
+func (h handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.JwtClaimsFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	r.ParseForm()
+	err = h.service.ChangePassword(r.Context(), ChangePasswordReq{
+		UserID:         claims.ID,
+		RawPassword:    r.Form.Get("password"),
+		NewRawPassword: r.Form.Get("newPassword"),
+	})
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	h.loggedOut(w)
+}
+
+func (h handler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.JwtClaimsFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	r.ParseForm()
+	err = h.service.DeleteAccount(r.Context(), DeleteAccountReq{
+		UserID:      claims.ID,
+		RawPassword: r.Form.Get("password"),
+	})
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	h.cookies.ClearSession(w)
+	site.ResetCSRFToken(w, h.cookies)
+
+	htmx.NewResponse().
+		Redirect("/signup").
+		Write(w)
+}
+
+func (h handler) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.JwtClaimsFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	r.ParseForm()
+	token, err := h.service.CreateAPIToken(r.Context(), CreateAPITokenReq{
+		UserID: claims.ID,
+		Name:   r.Form.Get("name"),
+		Scopes: r.Form["scope"],
+	})
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	h.renderAPITokens(w, r, claims.ID, token)
+}
+
+func (h handler) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.JwtClaimsFromRequest(r)
+	if err != nil {
+		site.RenderError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	err = h.service.RevokeAPIToken(r.Context(), RevokeAPITokenReq{
+		UserID:  claims.ID,
+		TokenID: chi.URLParam(r, "id"),
+	})
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	h.renderAPITokens(w, r, claims.ID, "")
+}
+
+// Render the API tokens section of the account page, showing newToken if it
+// was just made
+func (h handler) renderAPITokens(w http.ResponseWriter, r *http.Request, userID string, newToken string) {
+	tokens, err := h.service.GetAPITokens(r.Context(), userID)
+	if err != nil {
+		site.RenderError(w, svc.StatusCode(err), err)
+		return
+	}
+
+	apiTokens(tokens, newToken).Render(r.Context(), w)
+}
+
+// Send the browser to log in again after its session was ended
+func (h handler) loggedOut(w http.ResponseWriter) {
+	h.cookies.ClearSession(w)
+	site.ResetCSRFToken(w, h.cookies)
+
+	htmx.NewResponse().
+		Redirect(auth.LoginURL("/account")).
+		Write(w)
 }
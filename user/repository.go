@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
@@ -11,11 +12,18 @@ import (
 
 type (
 	Repository interface {
-		Migrate() error
 		CreateUser(ctx context.Context, u *User) error
 		GetUserByUsername(ctx context.Context, username Username) (*User, error)
 		GetUserByEmail(ctx context.Context, email Email) (*User, error)
 		GetUserByID(ctx context.Context, uuid uuid.UUID) (*User, error)
+		SetEmailVerified(ctx context.Context, id uuid.UUID, at time.Time) error
+		UpdatePassword(ctx context.Context, id uuid.UUID, password HashedPassword) error
+		// Fails with ErrDuplicate if another user has the username
+		UpdateUsername(ctx context.Context, id uuid.UUID, username Username) error
+		// Fails with ErrDuplicate if another user has the email, and marks
+		// the email as unverified
+		UpdateEmail(ctx context.Context, id uuid.UUID, email Email) error
+		DeleteUser(ctx context.Context, id uuid.UUID) error
 	}
 
 	redisRepository struct {
@@ -42,10 +50,6 @@ func NewRedisRepository(redis *redis.Client) Repository {
 	}
 }
 
-func (repo redisRepository) Migrate() error {
-	return nil
-}
-
 func (repo redisRepository) CreateUser(ctx context.Context, u *User) (err error) {
 	id := u.ID.String()
 
@@ -56,6 +60,9 @@ func (repo redisRepository) CreateUser(ctx context.Context, u *User) (err error)
 		"email":          string(u.Email),
 		"hashedPassword": string(u.HashedPassword),
 	}
+	if u.IsEmailVerified() {
+		m["emailVerifiedAt"] = u.EmailVerifiedAt
+	}
 
 	pipe := repo.redis.TxPipeline()
 
@@ -93,6 +100,10 @@ func (repo redisRepository) GetUserByID(ctx context.Context, uuid uuid.UUID) (*U
 		return nil, cmd.Err()
 	}
 
+	if len(cmd.Val()) == 0 {
+		return nil, ErrNotExists
+	}
+
 	u := new(User)
 
 	err := cmd.Scan(u)
@@ -115,3 +126,83 @@ func (repo redisRepository) GetUserByUsername(ctx context.Context, username User
 	}
 	return repo.GetUserByID(ctx, id)
 }
+
+func (repo redisRepository) SetEmailVerified(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return repo.setField(ctx, id, "emailVerifiedAt", at)
+}
+
+func (repo redisRepository) UpdatePassword(ctx context.Context, id uuid.UUID, password HashedPassword) error {
+	return repo.setField(ctx, id, "hashedPassword", string(password))
+}
+
+func (repo redisRepository) UpdateUsername(ctx context.Context, id uuid.UUID, username Username) error {
+	return repo.setIndexedField(ctx, id, redisUsernameIndex, "username", string(username))
+}
+
+func (repo redisRepository) UpdateEmail(ctx context.Context, id uuid.UUID, email Email) error {
+	return repo.setIndexedField(ctx, id, redisEmailIndex, "email", string(email), "emailVerifiedAt")
+}
+
+// Set a field that is kept unique by an index, failing with ErrDuplicate if
+// another user has the value. The unset fields are deleted along with it.
+func (repo redisRepository) setIndexedField(ctx context.Context, id uuid.UUID, index string, field string, value string, unset ...string) error {
+	key := fmt.Sprintf(redisFmtUser, id.String())
+
+	// Watch the index so that two users can't take the same value at once
+	return repo.redis.Watch(ctx, func(tx *redis.Tx) error {
+		owner, err := tx.HGet(ctx, index, value).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if err == nil && owner != id.String() {
+			return ErrDuplicate
+		}
+
+		old, err := tx.HGet(ctx, key, field).Result()
+		if errors.Is(err, redis.Nil) {
+			return ErrNotExists
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, index, old)
+			pipe.HSet(ctx, index, value, id.String())
+			pipe.HSet(ctx, key, field, value)
+			if len(unset) != 0 {
+				pipe.HDel(ctx, key, unset...)
+			}
+			return nil
+		})
+		return err
+	}, index)
+}
+
+func (repo redisRepository) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	u, err := repo.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	pipe := repo.redis.TxPipeline()
+	pipe.Del(ctx, fmt.Sprintf(redisFmtUser, id.String()))
+	pipe.HDel(ctx, redisUsernameIndex, string(u.Username))
+	pipe.HDel(ctx, redisEmailIndex, string(u.Email))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Set a field of an existing user
+func (repo redisRepository) setField(ctx context.Context, id uuid.UUID, field string, value any) error {
+	key := fmt.Sprintf(redisFmtUser, id.String())
+
+	n, err := repo.redis.Exists(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotExists
+	}
+
+	return repo.redis.HSet(ctx, key, field, value).Err()
+}
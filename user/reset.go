@@ -0,0 +1,71 @@
+// reset.go provides resetting forgotten passwords with links emailed to users
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/angelofallars/htmx-chi-todo/mail"
+	"github.com/google/uuid"
+)
+
+const resetTokenTTL = time.Hour
+
+var ErrInvalidResetToken = errors.New("This password reset link is invalid or has expired.")
+
+type (
+	// A password reset token as it is stored, which is only by its hash
+	ResetToken struct {
+		Hash      string
+		UserID    uuid.UUID
+		ExpiresAt time.Time
+	}
+
+	ResetTokenStore interface {
+		// Store a token, replacing any earlier tokens of the user
+		CreateResetToken(ctx context.Context, t *ResetToken) error
+		// Delete a token and return it, so it can only be used once.
+		// Returns ErrInvalidResetToken if it doesn't exist.
+		UseResetToken(ctx context.Context, hash string) (*ResetToken, error)
+		DeleteResetTokens(ctx context.Context, userID uuid.UUID) error
+	}
+)
+
+func newResetToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func resetEmail(u *User, baseURL string, token string) mail.Message {
+	link := baseURL + "/reset-password?token=" + url.QueryEscape(token)
+
+	return mail.Message{
+		To:      string(u.Email),
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(`Hi %v,
+
+Open the link below to choose a new password:
+
+%v
+
+The link expires in %v minutes and works only once. If you didn't ask to
+reset your password, you can ignore this email.
+`, u.Username, link, int(resetTokenTTL.Minutes())),
+	}
+}
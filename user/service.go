@@ -3,31 +3,105 @@ package user
 import (
 	"context"
 	"errors"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/angelofallars/htmx-chi-todo/auth"
+	"github.com/angelofallars/htmx-chi-todo/mail"
+	"github.com/angelofallars/htmx-chi-todo/ratelimit"
 	"github.com/angelofallars/htmx-chi-todo/service"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type (
 	Service interface {
 		Signup(ctx context.Context, req SignupReq) error
-		Login(ctx context.Context, req LoginReq) (string, error)
+		Login(ctx context.Context, req LoginReq) (*auth.TokenPair, error)
+		// Exchange a refresh token for a new pair of tokens
+		Refresh(ctx context.Context, refreshToken string) (*auth.TokenPair, error)
+		Logout(ctx context.Context, accessToken string, refreshToken string) error
+		// End every session of the user. API tokens keep working, since
+		// they are managed on their own from the account page.
+		LogoutAll(ctx context.Context, userID string) error
+		// Activate the account of a token emailed to a user
+		VerifyEmail(ctx context.Context, token string) error
+		// Email a link to reset the password, if the email has an account
+		ForgotPassword(ctx context.Context, email string) error
+		// Change the password with a token from ForgotPassword, logging the
+		// user out everywhere and revoking their API tokens
+		ResetPassword(ctx context.Context, req ResetPasswordReq) error
+		GetAccount(ctx context.Context, userID string) (*User, error)
+		// Changing the username or password logs the user out everywhere
+		ChangeUsername(ctx context.Context, req ChangeUsernameReq) error
+		// Email a link to verify the new email
+		ChangeEmail(ctx context.Context, req ChangeEmailReq) error
+		// Log the user out everywhere and revoke their API tokens, since
+		// they may have been made by whoever knew the old password
+		ChangePassword(ctx context.Context, req ChangePasswordReq) error
+		// Delete the account along with everything the user has
+		DeleteAccount(ctx context.Context, req DeleteAccountReq) error
+		// Make an API token for scripts to use the API as the user, which
+		// can't be shown again
+		CreateAPIToken(ctx context.Context, req CreateAPITokenReq) (string, error)
+		GetAPITokens(ctx context.Context, userID string) ([]*auth.APIToken, error)
+		RevokeAPIToken(ctx context.Context, req RevokeAPITokenReq) error
 	}
 
 	userService struct {
-		repo Repository
+		repo     Repository
+		resets   ResetTokenStore
+		sessions auth.Sessions
+		emails   Emails
+		limits   LoginLimits
+		data     UserData
+	}
+
+	// What other packages keep of users, such as their todo lists
+	UserData interface {
+		DeleteUserData(ctx context.Context, userID *uuid.UUID) error
+	}
+
+	// What's needed to email users links to their account, such as to
+	// verify their email or reset their password
+	Emails struct {
+		Keys   auth.KeyProvider
+		Mailer mail.Mailer
+		// Where the site is hosted, for the links in emails
+		BaseURL string
+	}
+
+	// Limits on failed logins, so passwords can't be guessed by brute force
+	LoginLimits struct {
+		// Failures from an IP address, across all usernames
+		ByIP ratelimit.Limiter
+		// Failures for a username, across all IP addresses
+		ByUsername ratelimit.Limiter
 	}
 )
 
-func NewService(repo Repository) Service {
+func NewService(repo Repository, resets ResetTokenStore, sessions auth.Sessions, emails Emails, limits LoginLimits, data UserData) Service {
 	return &userService{
-		repo: repo,
+		repo:     repo,
+		resets:   resets,
+		sessions: sessions,
+		emails:   emails,
+		limits:   limits,
+		data:     data,
 	}
 }
 
+var (
+	// Returned for both unknown usernames and wrong passwords, so it can't be
+	// used to find out which usernames exist.
+	ErrIncorrectLogin = errors.New("Incorrect username or password.")
+	// Only returned after the password is checked
+	ErrEmailNotVerified = errors.New("Please verify your email address first, we have sent you a new link.")
+	// The current password, when changing account settings
+	ErrIncorrectPassword = errors.New("Incorrect password.")
+)
+
 // These are the DTOs (data transfer objects)
 type (
 	SignupReq struct {
@@ -37,10 +111,58 @@ type (
 	}
 )
 
+type (
+	ResetPasswordReq struct {
+		Token       string
+		RawPassword string
+	}
+)
+
+// Each takes the ID of the logged in user, and all but ChangeUsernameReq
+// need their current password
+type (
+	ChangeUsernameReq struct {
+		UserID   string
+		Username string
+	}
+
+	ChangeEmailReq struct {
+		UserID      string
+		Email       string
+		RawPassword string
+	}
+
+	ChangePasswordReq struct {
+		UserID         string
+		RawPassword    string
+		NewRawPassword string
+	}
+
+	DeleteAccountReq struct {
+		UserID      string
+		RawPassword string
+	}
+)
+
+type (
+	CreateAPITokenReq struct {
+		UserID string
+		Name   string
+		Scopes []string
+	}
+
+	RevokeAPITokenReq struct {
+		UserID  string
+		TokenID string
+	}
+)
+
 type (
 	LoginReq struct {
 		Username    string
 		RawPassword string
+		// Where the request came from, for rate limiting
+		IP string
 	}
 )
 
@@ -71,37 +193,363 @@ func (svc userService) Signup(ctx context.Context, req SignupReq) error {
 	if err != nil {
 		return err
 	}
+
+	// The account exists either way, and logging in sends another link
+	err = svc.sendVerificationEmail(ctx, user)
+	if err != nil {
+		log.Printf("error sending verification email to user %v: %v", user.ID, err)
+	}
+
 	return nil
 }
 
-func (svc userService) Login(ctx context.Context, req LoginReq) (string, error) {
+func (svc userService) sendVerificationEmail(ctx context.Context, u *User) error {
+	token, err := newVerificationToken(svc.emails.Keys, u)
+	if err != nil {
+		return err
+	}
+
+	msg := verificationEmail(u, svc.emails.BaseURL, token)
+	return svc.emails.Mailer.Send(ctx, msg)
+}
+
+func (svc userService) VerifyEmail(ctx context.Context, token string) error {
+	id, email, err := parseVerificationToken(svc.emails.Keys, token)
+	if err != nil {
+		return errors.Join(service.ErrValidation, err)
+	}
+
+	u, err := svc.repo.GetUserByID(ctx, id)
+	if err != nil || u.Email != email {
+		return errors.Join(service.ErrValidation, ErrInvalidVerificationToken)
+	}
+
+	// Opening the link again is harmless
+	if u.IsEmailVerified() {
+		return nil
+	}
+
+	return svc.repo.SetEmailVerified(ctx, u.ID, time.Now())
+}
+
+func (svc userService) Login(ctx context.Context, req LoginReq) (*auth.TokenPair, error) {
+	ipKey := "login:ip:" + req.IP
+	usernameKey := "login:username:" + strings.ToLower(req.Username)
+
+	// Checked before bcrypt, so locked out clients cost next to nothing
+	err := svc.limits.ByUsername.Check(ctx, usernameKey)
+	if err == nil {
+		err = svc.limits.ByIP.Check(ctx, ipKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	u, err := svc.repo.GetUserByUsername(ctx, req.Username)
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(u.HashedPassword), []byte(req.RawPassword))
+	}
+
+	if err != nil {
+		_, ipErr := svc.limits.ByIP.Fail(ctx, ipKey)
+		_, usernameErr := svc.limits.ByUsername.Fail(ctx, usernameKey)
+		if err := errors.Join(ipErr, usernameErr); err != nil {
+			return nil, err
+		}
+		return nil, ErrIncorrectLogin
+	}
+
+	// Not the IP address, or logging in to an account of their own would
+	// let someone keep guessing the passwords of others
+	err = svc.limits.ByUsername.Reset(ctx, usernameKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.IsEmailVerified() {
+		err = svc.sendVerificationEmail(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrEmailNotVerified
+	}
+
+	return svc.sessions.Start(ctx, u.ID.String(), string(u.Username))
+}
+
+func (svc userService) Refresh(ctx context.Context, refreshToken string) (*auth.TokenPair, error) {
+	pair, err := svc.sessions.Refresh(ctx, refreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+		return nil, errors.Join(service.ErrUnauthorized, err)
+	}
+	return pair, err
+}
+
+func (svc userService) Logout(ctx context.Context, accessToken string, refreshToken string) error {
+	return svc.sessions.End(ctx, accessToken, refreshToken)
+}
+
+func (svc userService) LogoutAll(ctx context.Context, userID string) error {
+	return svc.sessions.EndAll(ctx, userID)
+}
+
+func (svc userService) ForgotPassword(ctx context.Context, email string) error {
+	// Not saying whether there is an account, so this can't be used to find
+	// out whose emails have one
+	u, err := svc.repo.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil
+	}
+
+	token, err := newResetToken()
+	if err != nil {
+		return err
+	}
+
+	err = svc.resets.CreateResetToken(ctx, &ResetToken{
+		Hash:      hashResetToken(token),
+		UserID:    u.ID,
+		ExpiresAt: time.Now().Add(resetTokenTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := resetEmail(u, svc.emails.BaseURL, token)
+	return svc.emails.Mailer.Send(ctx, msg)
+}
+
+func (svc userService) ResetPassword(ctx context.Context, req ResetPasswordReq) error {
+	// Checked first, so a password that's too short doesn't use up the link
+	password, err := NewHashedPassword(req.RawPassword)
+	if err != nil {
+		return errors.Join(service.ErrValidation, err)
+	}
+
+	t, err := svc.resets.UseResetToken(ctx, hashResetToken(req.Token))
+	if errors.Is(err, ErrInvalidResetToken) {
+		return errors.Join(service.ErrValidation, err)
+	} else if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if now.After(t.ExpiresAt) {
+		return errors.Join(service.ErrValidation, ErrInvalidResetToken)
+	}
+
+	u, err := svc.repo.GetUserByID(ctx, t.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = svc.repo.UpdatePassword(ctx, u.ID, password)
+	if err != nil {
+		return err
+	}
+
+	// Opening the emailed link proves the email is theirs
+	if !u.IsEmailVerified() {
+		err = svc.repo.SetEmailVerified(ctx, u.ID, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Whoever knew the old password must lose access, including through
+	// API tokens they made, as well as anyone with an older link
+	return errors.Join(
+		svc.resets.DeleteResetTokens(ctx, u.ID),
+		svc.sessions.EndAll(ctx, u.ID.String()),
+		svc.sessions.RevokeAPITokens(ctx, u.ID.String()),
+	)
+}
+
+func (svc userService) GetAccount(ctx context.Context, userID string) (*User, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, service.ErrUnauthorized
+	}
+
+	u, err := svc.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, errors.Join(service.ErrNotExists, err)
+	}
+
+	return u, nil
+}
+
+// Get the account of the user if the password is theirs. Failures count
+// towards the login limits, so the password can't be guessed here instead.
+func (svc userService) checkPassword(ctx context.Context, userID string, rawPassword string) (*User, error) {
+	u, err := svc.GetAccount(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	usernameKey := "login:username:" + strings.ToLower(string(u.Username))
+	err = svc.limits.ByUsername.Check(ctx, usernameKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(u.HashedPassword), []byte(rawPassword))
+	if err != nil {
+		_, err := svc.limits.ByUsername.Fail(ctx, usernameKey)
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.Join(service.ErrValidation, ErrIncorrectPassword)
+	}
+
+	return u, nil
+}
+
+func (svc userService) ChangeUsername(ctx context.Context, req ChangeUsernameReq) error {
+	u, err := svc.GetAccount(ctx, req.UserID)
+	if err != nil {
+		return err
+	}
+
+	username, err := NewUsername(req.Username)
+	if err != nil {
+		return errors.Join(service.ErrValidation, err)
+	}
+
+	err = svc.repo.UpdateUsername(ctx, u.ID, username)
+	if errors.Is(err, ErrDuplicate) {
+		return errors.Join(service.ErrValidation, errors.New("Username already exists"))
+	} else if err != nil {
+		return err
+	}
+
+	// Tokens have the username in them, so the old ones have to go
+	return svc.sessions.EndAll(ctx, u.ID.String())
+}
+
+func (svc userService) ChangeEmail(ctx context.Context, req ChangeEmailReq) error {
+	u, err := svc.checkPassword(ctx, req.UserID, req.RawPassword)
+	if err != nil {
+		return err
+	}
+
+	email, err := NewEmail(req.Email)
+	if err != nil {
+		return errors.Join(service.ErrValidation, err)
+	}
+	if email == u.Email {
+		return nil
+	}
+
+	err = svc.repo.UpdateEmail(ctx, u.ID, email)
+	if errors.Is(err, ErrDuplicate) {
+		return errors.Join(service.ErrValidation, errors.New("Email is already in use"))
+	} else if err != nil {
+		return err
+	}
+
+	// Links sent to the old email must not reset the password anymore
+	err = svc.resets.DeleteResetTokens(ctx, u.ID)
 	if err != nil {
-		return "", errors.New("Incorrect email or password.")
+		return err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(u.HashedPassword), []byte(req.RawPassword))
+	u.Email = email
+	u.EmailVerifiedAt = time.Time{}
+	return svc.sendVerificationEmail(ctx, u)
+}
+
+func (svc userService) ChangePassword(ctx context.Context, req ChangePasswordReq) error {
+	u, err := svc.checkPassword(ctx, req.UserID, req.RawPassword)
+	if err != nil {
+		return err
+	}
+
+	password, err := NewHashedPassword(req.NewRawPassword)
 	if err != nil {
-		return "", errors.New("Incorrect email or password.")
+		return errors.Join(service.ErrValidation, err)
 	}
 
-	claims := &auth.JwtClaims{
-		ID:       u.ID.String(),
-		Username: string(u.Username),
-		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 72)),
-		},
+	err = svc.repo.UpdatePassword(ctx, u.ID, password)
+	if err != nil {
+		return err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return errors.Join(
+		svc.resets.DeleteResetTokens(ctx, u.ID),
+		svc.sessions.EndAll(ctx, u.ID.String()),
+		svc.sessions.RevokeAPITokens(ctx, u.ID.String()),
+	)
+}
 
-	// TODO: set up a system for getting JWT key
-	signedToken, err := token.SignedString([]byte("secret"))
+func (svc userService) DeleteAccount(ctx context.Context, req DeleteAccountReq) error {
+	u, err := svc.checkPassword(ctx, req.UserID, req.RawPassword)
+	if err != nil {
+		return err
+	}
 
+	// Logged out first, so nothing new is made while the rest is deleted
+	err = errors.Join(
+		svc.sessions.EndAll(ctx, u.ID.String()),
+		svc.sessions.RevokeAPITokens(ctx, u.ID.String()),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = svc.data.DeleteUserData(ctx, &u.ID)
+	if err != nil {
+		return err
+	}
+
+	err = svc.resets.DeleteResetTokens(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+
+	return svc.repo.DeleteUser(ctx, u.ID)
+}
+
+func (svc userService) CreateAPIToken(ctx context.Context, req CreateAPITokenReq) (string, error) {
+	u, err := svc.GetAccount(ctx, req.UserID)
 	if err != nil {
 		return "", err
 	}
 
-	return signedToken, nil
+	name, err := newAPITokenName(req.Name)
+	if err != nil {
+		return "", errors.Join(service.ErrValidation, err)
+	}
+	scopes, err := newAPITokenScopes(req.Scopes)
+	if err != nil {
+		return "", errors.Join(service.ErrValidation, err)
+	}
+
+	tokens, err := svc.sessions.GetAPITokens(ctx, u.ID.String())
+	if err != nil {
+		return "", err
+	}
+	if len(tokens) >= maxAPITokens {
+		return "", errors.Join(service.ErrValidation, errors.New("You have too many API tokens, revoke one first"))
+	}
+
+	token, _, err := svc.sessions.CreateAPIToken(ctx, u.ID.String(), name, scopes)
+	return token, err
+}
+
+func (svc userService) GetAPITokens(ctx context.Context, userID string) ([]*auth.APIToken, error) {
+	u, err := svc.GetAccount(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.sessions.GetAPITokens(ctx, u.ID.String())
+}
+
+func (svc userService) RevokeAPIToken(ctx context.Context, req RevokeAPITokenReq) error {
+	err := svc.sessions.RevokeAPIToken(ctx, req.UserID, req.TokenID)
+	if errors.Is(err, auth.ErrInvalidAPIToken) {
+		return errors.Join(service.ErrNotExists, err)
+	}
+	return err
 }
@@ -0,0 +1,700 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angelofallars/htmx-chi-todo/auth"
+	"github.com/angelofallars/htmx-chi-todo/config"
+	"github.com/angelofallars/htmx-chi-todo/mail"
+	"github.com/angelofallars/htmx-chi-todo/migrate"
+	"github.com/angelofallars/htmx-chi-todo/ratelimit"
+	svc "github.com/angelofallars/htmx-chi-todo/service"
+	"github.com/angelofallars/htmx-chi-todo/todo"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type recordingMailer struct {
+	sent []mail.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var tokenPattern = regexp.MustCompile(`\?token=(\S+)`)
+
+// The token in the link of the last email sent
+func (m *recordingMailer) lastToken(t *testing.T) string {
+	t.Helper()
+
+	if len(m.sent) == 0 {
+		t.Fatal("no email was sent")
+	}
+	match := tokenPattern.FindStringSubmatch(m.sent[len(m.sent)-1].Body)
+	if match == nil {
+		t.Fatal("email has no link with a token")
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+type testService struct {
+	Service
+	repo     *SQLiteRepository
+	resets   *SQLiteResetTokenStore
+	mailer   *recordingMailer
+	keys     auth.KeyProvider
+	sessions auth.Sessions
+	todos    todo.Service
+}
+
+func newTestService(t *testing.T) *testService {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to ":memory:" opens a separate database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, migrate.Migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	hmac, err := auth.NewHMACKey("hmac", []byte("a-test-secret-of-at-least-32-bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeyProvider(hmac)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewSQLiteRepository(db)
+	resets := NewSQLiteResetTokenStore(db)
+	todos := todo.NewService(todo.NewSQLiteRepository(db), todo.NewMemoryEventBus(), NewDirectory(repo))
+	sessions := auth.NewSessions(keys, auth.NewSQLiteTokenStore(db), auth.NewCookies(true), config.Default().Auth)
+	mailer := &recordingMailer{}
+	policy := ratelimit.Policy{FreeFailures: 100, BaseLockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+
+	service := NewService(repo, resets, sessions,
+		Emails{
+			Keys:    keys,
+			Mailer:  mailer,
+			BaseURL: "https://todo.example.com",
+		},
+		LoginLimits{
+			ByIP:       ratelimit.NewLimiter(ratelimit.NewMemoryStore(), policy),
+			ByUsername: ratelimit.NewLimiter(ratelimit.NewMemoryStore(), policy),
+		},
+		todos,
+	)
+
+	return &testService{
+		Service:  service,
+		repo:     repo,
+		resets:   resets,
+		mailer:   mailer,
+		keys:     keys,
+		sessions: sessions,
+		todos:    todos,
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	service := newTestService(t)
+	repo, mailer := service.repo, service.mailer
+	ctx := context.Background()
+
+	err := service.Signup(ctx, SignupReq{Username: "alice", RawPassword: "password123", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0].To != "alice@example.com" {
+		t.Fatalf("got emails %+v, want one to alice@example.com", mailer.sent)
+	}
+
+	login := LoginReq{Username: "alice", RawPassword: "password123", IP: "127.0.0.1"}
+	if _, err := service.Login(ctx, login); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("got %v, want ErrEmailNotVerified", err)
+	}
+	if len(mailer.sent) != 2 {
+		t.Errorf("got %v emails, want logging in to send another link", len(mailer.sent))
+	}
+
+	if _, err := service.Login(ctx, LoginReq{Username: "alice", RawPassword: "wrong password"}); !errors.Is(err, ErrIncorrectLogin) {
+		t.Errorf("got %v, want a wrong password to not say whether the email is verified", err)
+	}
+
+	if err := service.VerifyEmail(ctx, mailer.lastToken(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := repo.GetUserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.IsEmailVerified() || u.Email != "alice@example.com" {
+		t.Errorf("got user %+v, want a verified email", u)
+	}
+
+	if _, err := service.Login(ctx, login); err != nil {
+		t.Errorf("login after verifying: %v", err)
+	}
+
+	// Opening the link again still works
+	if err := service.VerifyEmail(ctx, mailer.lastToken(t)); err != nil {
+		t.Errorf("verifying again: %v", err)
+	}
+}
+
+func TestVerifyEmailRejectsInvalidTokens(t *testing.T) {
+	service := newTestService(t)
+	repo, mailer, keys := service.repo, service.mailer, service.keys
+	ctx := context.Background()
+
+	err := service.Signup(ctx, SignupReq{Username: "alice", RawPassword: "password123", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := mailer.lastToken(t)
+
+	u, err := repo.GetUserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A link for an email the user no longer has
+	changed := *u
+	changed.Email = "mallory@example.com"
+	otherEmailToken, err := newVerificationToken(keys, &changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens for logging in aren't verification tokens
+	accessToken, err := keys.Sign(auth.JwtClaims{ID: u.ID.String(), Username: u.Username})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"empty":         "",
+		"tampered":      token[:len(token)-2] + "xx",
+		"other email":   otherEmailToken,
+		"access token":  accessToken,
+		"not a token":   "not-a-token",
+		"extra segment": token + ".extra",
+	}
+
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := service.VerifyEmail(ctx, token)
+			if !errors.Is(err, ErrInvalidVerificationToken) || svc.StatusCode(err) != 400 {
+				t.Errorf("got %v, want ErrInvalidVerificationToken", err)
+			}
+		})
+	}
+
+	u, err = repo.GetUserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.IsEmailVerified() {
+		t.Error("email was verified by an invalid token")
+	}
+}
+
+// Sign up a user and verify their email
+func (s *testService) signup(t *testing.T, username string, password string, email string) {
+	t.Helper()
+	ctx := context.Background()
+
+	err := s.Signup(ctx, SignupReq{Username: username, RawPassword: password, Email: email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifyEmail(ctx, s.mailer.lastToken(t)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	service := newTestService(t)
+	ctx := context.Background()
+
+	service.signup(t, "alice", "password123", "alice@example.com")
+	login := LoginReq{Username: "alice", RawPassword: "password123", IP: "127.0.0.1"}
+	tokens, err := service.Login(ctx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := service.login(t, "alice", "password123")
+	apiToken, err := service.CreateAPIToken(ctx, CreateAPITokenReq{UserID: id.String(), Name: "script", Scopes: []string{"write"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !service.apiTokenWorks(t, apiToken) {
+		t.Fatal("API token doesn't work before the reset")
+	}
+
+	sent := len(service.mailer.sent)
+	if err := service.ForgotPassword(ctx, "nobody@example.com"); err != nil {
+		t.Errorf("got %v, want no error for an email without an account", err)
+	}
+	if len(service.mailer.sent) != sent {
+		t.Error("sent an email to an email without an account")
+	}
+
+	if err := service.ForgotPassword(ctx, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	resetToken := service.mailer.lastToken(t)
+
+	err = service.ResetPassword(ctx, ResetPasswordReq{Token: resetToken, RawPassword: "short"})
+	if !errors.Is(err, svc.ErrValidation) {
+		t.Errorf("got %v, want a validation error", err)
+	}
+
+	err = service.ResetPassword(ctx, ResetPasswordReq{Token: resetToken, RawPassword: "newpassword123"})
+	if err != nil {
+		t.Fatalf("a too short password used up the link: %v", err)
+	}
+
+	err = service.ResetPassword(ctx, ResetPasswordReq{Token: resetToken, RawPassword: "otherpassword123"})
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("got %v, want the link to only work once", err)
+	}
+
+	if _, err := service.sessions.Verify(ctx, tokens.AccessToken); err == nil {
+		t.Error("session from before the reset still works")
+	}
+	if service.apiTokenWorks(t, apiToken) {
+		t.Error("API token from before the reset still works")
+	}
+	if _, err := service.Login(ctx, login); !errors.Is(err, ErrIncorrectLogin) {
+		t.Errorf("got %v, want the old password to stop working", err)
+	}
+
+	login.RawPassword = "newpassword123"
+	if _, err := service.Login(ctx, login); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+}
+
+func TestResetPasswordRejectsInvalidTokens(t *testing.T) {
+	service := newTestService(t)
+	ctx := context.Background()
+
+	service.signup(t, "alice", "password123", "alice@example.com")
+
+	if err := service.ForgotPassword(ctx, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	older := service.mailer.lastToken(t)
+
+	if err := service.ForgotPassword(ctx, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	latest := service.mailer.lastToken(t)
+
+	u, err := service.repo.GetUserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := newResetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.resets.CreateResetToken(ctx, &ResetToken{
+		Hash:      hashResetToken(expired),
+		UserID:    u.ID,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"empty":              "",
+		"unknown":            "not-a-token",
+		"replaced by latest": older,
+		"expired":            expired,
+		// Also replaced by the expired token
+		"latest": latest,
+	}
+
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := service.ResetPassword(ctx, ResetPasswordReq{Token: token, RawPassword: "newpassword123"})
+			if !errors.Is(err, ErrInvalidResetToken) || svc.StatusCode(err) != 400 {
+				t.Errorf("got %v, want ErrInvalidResetToken", err)
+			}
+		})
+	}
+}
+
+// Whether an API token authenticates requests to the API
+func (s *testService) apiTokenWorks(t *testing.T, token string) bool {
+	t.Helper()
+
+	var err error
+	h := s.sessions.BearerVerifier()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, err = jwtauth.FromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/lists", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	return err == nil
+}
+
+// Log in, returning the user's ID and access token
+func (s *testService) login(t *testing.T, username string, password string) (uuid.UUID, string) {
+	t.Helper()
+
+	tokens, err := s.Login(context.Background(), LoginReq{Username: username, RawPassword: password, IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.repo.GetUserByUsername(context.Background(), username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.ID, tokens.AccessToken
+}
+
+func TestChangeUsername(t *testing.T) {
+	service := newTestService(t)
+	ctx := context.Background()
+
+	service.signup(t, "alice", "password123", "alice@example.com")
+	service.signup(t, "bobby", "password123", "bob@example.com")
+	id, accessToken := service.login(t, "alice", "password123")
+
+	err := service.ChangeUsername(ctx, ChangeUsernameReq{UserID: id.String(), Username: "bobby"})
+	if !errors.Is(err, svc.ErrValidation) {
+		t.Errorf("got %v, want taking another user's username to fail", err)
+	}
+	err = service.ChangeUsername(ctx, ChangeUsernameReq{UserID: id.String(), Username: "a!"})
+	if !errors.Is(err, svc.ErrValidation) {
+		t.Errorf("got %v, want an invalid username to fail", err)
+	}
+
+	err = service.ChangeUsername(ctx, ChangeUsernameReq{UserID: id.String(), Username: "alicia"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.sessions.Verify(ctx, accessToken); err == nil {
+		t.Error("token with the old username still works")
+	}
+	if _, err := service.Login(ctx, LoginReq{Username: "alice", RawPassword: "password123"}); !errors.Is(err, ErrIncorrectLogin) {
+		t.Errorf("got %v, want the old username to stop working", err)
+	}
+	service.login(t, "alicia", "password123")
+}
+
+func TestChangeEmail(t *testing.T) {
+	service := newTestService(t)
+	ctx := context.Background()
+
+	service.signup(t, "alice", "password123", "alice@example.com")
+	service.signup(t, "bobby", "password123", "bob@example.com")
+	id, _ := service.login(t, "alice", "password123")
+
+	if err := service.ForgotPassword(ctx, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	resetToken := service.mailer.lastToken(t)
+
+	req := ChangeEmailReq{UserID: id.String(), Email: "alice@example.org", RawPassword: "wrong password"}
+	if err := service.ChangeEmail(ctx, req); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("got %v, want ErrIncorrectPassword", err)
+	}
+
+	req.RawPassword = "password123"
+	req.Email = "bob@example.com"
+	if err := service.ChangeEmail(ctx, req); !errors.Is(err, svc.ErrValidation) {
+		t.Errorf("got %v, want taking another user's email to fail", err)
+	}
+
+	req.Email = "alice@example.org"
+	if err := service.ChangeEmail(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := service.GetAccount(ctx, id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Email != "alice@example.org" || u.IsEmailVerified() {
+		t.Errorf("got user %+v, want the new email to be unverified", u)
+	}
+
+	last := service.mailer.sent[len(service.mailer.sent)-1]
+	if last.To != "alice@example.org" {
+		t.Errorf("got email to %v, want a verification link sent to the new email", last.To)
+	}
+
+	err = service.ResetPassword(ctx, ResetPasswordReq{Token: resetToken, RawPassword: "newpassword123"})
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("got %v, want links sent to the old email to stop working", err)
+	}
+
+	if err := service.VerifyEmail(ctx, service.mailer.lastToken(t)); err != nil {
+		t.Fatal(err)
+	}
+	service.login(t, "alice", "password123")
+}
+
+func TestChangePassword(t *testing.T) {
+	service := newTestService(t)
+	ctx := context.Background()
+
+	service.signup(t, "alice", "password123", "alice@example.com")
+	id, accessToken := service.login(t, "alice", "password123")
+	apiToken, err := service.CreateAPIToken(ctx, CreateAPITokenReq{UserID: id.String(), Name: "script", Scopes: []string{"read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := ChangePasswordReq{UserID: id.String(), RawPassword: "wrong password", NewRawPassword: "newpassword123"}
+	if err := service.ChangePassword(ctx, req); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("got %v, want ErrIncorrectPassword", err)
+	}
+
+	req.RawPassword = "password123"
+	req.NewRawPassword = "short"
+	if err := service.ChangePassword(ctx, req); !errors.Is(err, svc.ErrValidation) {
+		t.Errorf("got %v, want a too short password to fail", err)
+	}
+
+	req.NewRawPassword = "newpassword123"
+	if err := service.ChangePassword(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.sessions.Verify(ctx, accessToken); err == nil {
+		t.Error("session from before the change still works")
+	}
+	if service.apiTokenWorks(t, apiToken) {
+		t.Error("API token from before the change still works")
+	}
+	service.login(t, "alice", "newpassword123")
+}
+
+func TestLogoutAllKeepsAPITokens(t *testing.T) {
+	service := newTestService(t)
+	ctx := context.Background()
+
+	service.signup(t, "alice", "password123", "alice@example.com")
+	id, accessToken := service.login(t, "alice", "password123")
+	apiToken, err := service.CreateAPIToken(ctx, CreateAPITokenReq{UserID: id.String(), Name: "script", Scopes: []string{"read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.LogoutAll(ctx, id.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.sessions.Verify(ctx, accessToken); err == nil {
+		t.Error("session still works")
+	}
+	if !service.apiTokenWorks(t, apiToken) {
+		t.Error("API token stopped working")
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	service := newTestService(t)
+	ctx := context.Background()
+
+	service.signup(t, "alice", "password123", "alice@example.com")
+	id, accessToken := service.login(t, "alice", "password123")
+
+	if _, err := service.todos.CreateExampleList(ctx, &id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.CreateAPIToken(ctx, CreateAPITokenReq{UserID: id.String(), Name: "script", Scopes: []string{"read"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := DeleteAccountReq{UserID: id.String(), RawPassword: "wrong password"}
+	if err := service.DeleteAccount(ctx, req); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("got %v, want ErrIncorrectPassword", err)
+	}
+
+	req.RawPassword = "password123"
+	if err := service.DeleteAccount(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.sessions.Verify(ctx, accessToken); err == nil {
+		t.Error("session of the deleted account still works")
+	}
+	if _, err := service.GetAccount(ctx, id.String()); !errors.Is(err, svc.ErrNotExists) {
+		t.Errorf("got %v, want the account to be gone", err)
+	}
+	if tokens, err := service.sessions.GetAPITokens(ctx, id.String()); err != nil || len(tokens) != 0 {
+		t.Errorf("got API tokens %v and error %v, want them to be deleted", tokens, err)
+	}
+	lists, err := service.todos.GetLists(ctx, &id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lists) != 0 {
+		t.Errorf("got %v lists, want the todo lists to be deleted", len(lists))
+	}
+
+	// The username and email are free again
+	service.signup(t, "alice", "password123", "alice@example.com")
+}
+
+func TestCreateAPIToken(t *testing.T) {
+	service := newTestService(t)
+	ctx := context.Background()
+
+	service.signup(t, "alice", "password123", "alice@example.com")
+	id, _ := service.login(t, "alice", "password123")
+
+	invalid := []CreateAPITokenReq{
+		{UserID: id.String(), Name: "  ", Scopes: []string{"read"}},
+		{UserID: id.String(), Name: strings.Repeat("a", 51), Scopes: []string{"read"}},
+		{UserID: id.String(), Name: "script"},
+		{UserID: id.String(), Name: "script", Scopes: []string{"admin"}},
+	}
+	for _, req := range invalid {
+		if _, err := service.CreateAPIToken(ctx, req); !errors.Is(err, svc.ErrValidation) {
+			t.Errorf("%+v: got %v, want ErrValidation", req, err)
+		}
+	}
+
+	token, err := service.CreateAPIToken(ctx, CreateAPITokenReq{
+		UserID: id.String(),
+		Name:   " backup script ",
+		Scopes: []string{"write", "read", "write"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" {
+		t.Fatal("got no token")
+	}
+
+	tokens, err := service.GetAPITokens(ctx, id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "backup script" || len(tokens[0].Scopes) != 2 {
+		t.Fatalf("got tokens %+v", tokens)
+	}
+
+	// Only the owner can revoke a token
+	service.signup(t, "mallory", "password123", "mallory@example.com")
+	otherID, _ := service.login(t, "mallory", "password123")
+	err = service.RevokeAPIToken(ctx, RevokeAPITokenReq{UserID: otherID.String(), TokenID: tokens[0].ID})
+	if !errors.Is(err, svc.ErrNotExists) {
+		t.Errorf("revoking another user's token: got %v, want ErrNotExists", err)
+	}
+
+	err = service.RevokeAPIToken(ctx, RevokeAPITokenReq{UserID: id.String(), TokenID: tokens[0].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _ := service.GetAPITokens(ctx, id.String()); len(tokens) != 0 {
+		t.Errorf("got tokens %+v after revoking", tokens)
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	service := newTestService(t)
+	directory := NewDirectory(service.repo)
+	ctx := context.Background()
+
+	service.signup(t, "alice", "password123", "alice@example.com")
+	id, _ := service.login(t, "alice", "password123")
+
+	for _, usernameOrEmail := range []string{"alice", "alice@example.com", " alice "} {
+		got, err := directory.FindUser(ctx, usernameOrEmail)
+		if err != nil {
+			t.Errorf("finding %q: %v", usernameOrEmail, err)
+		} else if got != id {
+			t.Errorf("finding %q: got %v, want %v", usernameOrEmail, got, id)
+		}
+	}
+
+	if _, err := directory.FindUser(ctx, "bob@example.com"); !errors.Is(err, svc.ErrNotExists) {
+		t.Errorf("finding a missing user: got %v, want ErrNotExists", err)
+	}
+
+	username, err := directory.GetUsername(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "alice" {
+		t.Errorf("got username %q, want alice", username)
+	}
+	if _, err := directory.GetUsername(ctx, uuid.New()); !errors.Is(err, svc.ErrNotExists) {
+		t.Errorf("getting the username of a missing user: got %v, want ErrNotExists", err)
+	}
+}
+
+// A repository whose lookups fail, as when the database is unavailable
+type failingRepository struct {
+	Repository
+}
+
+var errDatabaseDown = errors.New("database is locked")
+
+func (failingRepository) GetUserByUsername(ctx context.Context, username Username) (*User, error) {
+	return nil, errDatabaseDown
+}
+
+func (failingRepository) GetUserByEmail(ctx context.Context, email Email) (*User, error) {
+	return nil, errDatabaseDown
+}
+
+func (failingRepository) GetUserByID(ctx context.Context, id uuid.UUID) (*User, error) {
+	return nil, errDatabaseDown
+}
+
+func TestDirectoryPassesOnFailures(t *testing.T) {
+	directory := NewDirectory(failingRepository{})
+	ctx := context.Background()
+
+	for _, usernameOrEmail := range []string{"alice", "alice@example.com"} {
+		_, err := directory.FindUser(ctx, usernameOrEmail)
+		if !errors.Is(err, errDatabaseDown) || errors.Is(err, svc.ErrNotExists) {
+			t.Errorf("finding %q: got %v, want the repository's error", usernameOrEmail, err)
+		}
+	}
+
+	_, err := directory.GetUsername(ctx, uuid.New())
+	if !errors.Is(err, errDatabaseDown) || errors.Is(err, svc.ErrNotExists) {
+		t.Errorf("getting a username: got %v, want the repository's error", err)
+	}
+}
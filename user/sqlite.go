@@ -20,24 +20,14 @@ func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
 	}
 }
 
-func (r SQLiteRepository) Migrate() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS users(
-		id TEXT PRIMARY KEY,
-		username TEXT NOT NULL UNIQUE,
-		password TEXT NOT NULL,
-		email TEXT NOT NULL UNIQUE,
-		createdAt INTEGER NOT NULL
-	);
-	`
-
-	_, err := r.db.Exec(query)
-	return err
-}
-
 func (r SQLiteRepository) CreateUser(ctx context.Context, u *User) (err error) {
-	query := `INSERT INTO users( id, username, password, email, createdAt )
-						  values( ?, ?, ?, ?, ? )`
+	query := `INSERT INTO users( id, username, password, email, createdAt, emailVerifiedAt )
+						  values( ?, ?, ?, ?, ?, ? )`
+
+	var emailVerifiedAt sql.NullInt64
+	if u.IsEmailVerified() {
+		emailVerifiedAt = sql.NullInt64{Int64: u.EmailVerifiedAt.Unix(), Valid: true}
+	}
 
 	_, err = r.db.Exec(query,
 		u.ID.String(),
@@ -45,6 +35,7 @@ func (r SQLiteRepository) CreateUser(ctx context.Context, u *User) (err error) {
 		string(u.HashedPassword),
 		string(u.Email),
 		u.CreatedAt.Unix(),
+		emailVerifiedAt,
 	)
 
 	if err != nil {
@@ -59,18 +50,17 @@ func (r SQLiteRepository) CreateUser(ctx context.Context, u *User) (err error) {
 
 	return
 }
-func (r SQLiteRepository) GetUserByUsername(ctx context.Context, username Username) (*User, error) {
-	query := `SELECT id, password, email, createdAt
-			  FROM users
-			  WHERE username = ?`
 
-	row := r.db.QueryRow(query, string(username))
+const userColumns = `id, username, password, email, createdAt, emailVerifiedAt`
 
+func scanUser(row *sql.Row) (*User, error) {
 	var id string
+	var username string
 	var password string
 	var email string
 	var createdAt int64
-	err := row.Scan(&id, &password, &email, &createdAt)
+	var emailVerifiedAt sql.NullInt64
+	err := row.Scan(&id, &username, &password, &email, &createdAt, &emailVerifiedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -82,59 +72,169 @@ func (r SQLiteRepository) GetUserByUsername(ctx context.Context, username Userna
 		Email:          Email(email),
 		CreatedAt:      time.Unix(createdAt, 0),
 	}
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = time.Unix(emailVerifiedAt.Int64, 0)
+	}
 
 	return user, nil
 }
 
+func (r SQLiteRepository) GetUserByUsername(ctx context.Context, username Username) (*User, error) {
+	query := `SELECT ` + userColumns + `
+			  FROM users
+			  WHERE username = ?`
+
+	return scanUser(r.db.QueryRow(query, string(username)))
+}
+
 func (r SQLiteRepository) GetUserByEmail(ctx context.Context, email Email) (*User, error) {
-	query := `SELECT id, password, username, createdAt
+	query := `SELECT ` + userColumns + `
 			  FROM users
 			  WHERE email = ?`
 
-	row := r.db.QueryRow(query, string(email))
+	return scanUser(r.db.QueryRow(query, string(email)))
+}
 
-	var id string
-	var username string
-	var password string
-	var createdAt int64
-	err := row.Scan(&id, &password, &email, &createdAt)
+func (r SQLiteRepository) GetUserByID(ctx context.Context, uuid uuid.UUID) (*User, error) {
+	query := `SELECT ` + userColumns + `
+			  FROM users
+			  WHERE id = ?`
+
+	return scanUser(r.db.QueryRow(query, uuid.String()))
+}
+
+func (r SQLiteRepository) SetEmailVerified(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return r.updateUser(id, `emailVerifiedAt = ?`, at.Unix())
+}
+
+func (r SQLiteRepository) UpdatePassword(ctx context.Context, id uuid.UUID, password HashedPassword) error {
+	return r.updateUser(id, `password = ?`, string(password))
+}
+
+func (r SQLiteRepository) UpdateUsername(ctx context.Context, id uuid.UUID, username Username) error {
+	return r.updateUser(id, `username = ?`, string(username))
+}
+
+func (r SQLiteRepository) UpdateEmail(ctx context.Context, id uuid.UUID, email Email) error {
+	// A new email has to be verified again
+	return r.updateUser(id, `email = ?, emailVerifiedAt = NULL`, string(email))
+}
+
+// Update the user with the given SET clause, failing with ErrDuplicate if
+// it would take the username or email of another user
+func (r SQLiteRepository) updateUser(id uuid.UUID, set string, args ...any) error {
+	query := `UPDATE users SET ` + set + ` WHERE id = ?`
+
+	result, err := r.db.Exec(query, append(args, id.String())...)
 	if err != nil {
-		return nil, err
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && errors.Is(sqliteErr.ExtendedCode, sqlite3.ErrConstraintUnique) {
+			return ErrDuplicate
+		}
+		return err
 	}
 
-	user := &User{
-		ID:             uuid.MustParse(id),
-		Username:       Username(username),
-		HashedPassword: password,
-		Email:          Email(email),
-		CreatedAt:      time.Unix(createdAt, 0),
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotExists
 	}
 
-	return user, nil
+	return nil
 }
-func (r SQLiteRepository) GetUserByID(ctx context.Context, uuid uuid.UUID) (*User, error) {
-	query := `SELECT password, username, email, createdAt
-			  FROM users
-			  WHERE id = ?`
 
-	row := r.db.QueryRow(query, uuid.String())
+func (r SQLiteRepository) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	result, err := r.db.Exec(`DELETE FROM users WHERE id = ?`, id.String())
+	if err != nil {
+		return err
+	}
 
-	var username string
-	var email string
-	var password string
-	var createdAt int64
-	err := row.Scan(&username, &email, &password, &createdAt)
+	n, err := result.RowsAffected()
 	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotExists
+	}
+
+	return nil
+}
+
+type SQLiteResetTokenStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteResetTokenStore(db *sql.DB) *SQLiteResetTokenStore {
+	return &SQLiteResetTokenStore{
+		db: db,
+	}
+}
+
+func (s SQLiteResetTokenStore) CreateResetToken(ctx context.Context, t *ResetToken) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only the latest link works, and expired tokens don't pile up
+	_, err = tx.Exec(`DELETE FROM passwordResetTokens WHERE userId = ? OR expiresAt < ?`,
+		t.UserID.String(),
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO passwordResetTokens( hash, userId, expiresAt )
+					  values( ?, ?, ? )`,
+		t.Hash,
+		t.UserID.String(),
+		t.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s SQLiteResetTokenStore) UseResetToken(ctx context.Context, hash string) (*ResetToken, error) {
+	row := s.db.QueryRow(`SELECT userId, expiresAt FROM passwordResetTokens WHERE hash = ?`, hash)
+
+	var userID string
+	var expiresAt int64
+	err := row.Scan(&userID, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidResetToken
+	} else if err != nil {
 		return nil, err
 	}
 
-	user := &User{
-		ID:             uuid,
-		Username:       Username(username),
-		HashedPassword: password,
-		Email:          Email(email),
-		CreatedAt:      time.Unix(createdAt, 0),
+	// Only one request can delete the token
+	result, err := s.db.Exec(`DELETE FROM passwordResetTokens WHERE hash = ?`, hash)
+	if err != nil {
+		return nil, err
 	}
 
-	return user, nil
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrInvalidResetToken
+	}
+
+	return &ResetToken{
+		Hash:      hash,
+		UserID:    uuid.MustParse(userID),
+		ExpiresAt: time.Unix(expiresAt, 0),
+	}, nil
+}
+
+func (s SQLiteResetTokenStore) DeleteResetTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := s.db.Exec(`DELETE FROM passwordResetTokens WHERE userId = ?`, userID.String())
+	return err
 }
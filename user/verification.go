@@ -0,0 +1,85 @@
+// verification.go provides the links emailed to users to verify their email
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/angelofallars/htmx-chi-todo/auth"
+	"github.com/angelofallars/htmx-chi-todo/mail"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	verificationTokenTTL = 48 * time.Hour
+	// Keeps verification tokens from being used as access tokens, and the
+	// other way around
+	verificationAudience = "email-verification"
+)
+
+var ErrInvalidVerificationToken = errors.New("This verification link is invalid or has expired.")
+
+type verificationClaims struct {
+	// The email being verified, so the link stops working if it changes
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+func newVerificationToken(keys auth.KeyProvider, u *User) (string, error) {
+	now := time.Now()
+
+	return keys.Sign(verificationClaims{
+		Email: string(u.Email),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   u.ID.String(),
+			Audience:  jwt.ClaimStrings{verificationAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(verificationTokenTTL)),
+		},
+	})
+}
+
+// Get the user ID and email of a verification token
+func parseVerificationToken(keys auth.KeyProvider, token string) (uuid.UUID, Email, error) {
+	t, err := keys.VerifyToken(token)
+	if err != nil {
+		return uuid.Nil, "", ErrInvalidVerificationToken
+	}
+
+	if !slices.Contains(t.Audience(), verificationAudience) {
+		return uuid.Nil, "", ErrInvalidVerificationToken
+	}
+
+	id, err := uuid.Parse(t.Subject())
+	if err != nil {
+		return uuid.Nil, "", ErrInvalidVerificationToken
+	}
+
+	email, _ := t.PrivateClaims()["email"].(string)
+	if email == "" {
+		return uuid.Nil, "", ErrInvalidVerificationToken
+	}
+
+	return id, Email(email), nil
+}
+
+func verificationEmail(u *User, baseURL string, token string) mail.Message {
+	link := baseURL + "/verify?token=" + url.QueryEscape(token)
+
+	return mail.Message{
+		To:      string(u.Email),
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(`Hi %v,
+
+Open the link below to verify your email address and activate your account:
+
+%v
+
+The link expires in %v hours. If you didn't sign up, you can ignore this email.
+`, u.Username, link, int(verificationTokenTTL.Hours())),
+	}
+}